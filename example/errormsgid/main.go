@@ -21,10 +21,10 @@ func main() {
 			ErrorMsgID: func(c *network.Context) {
 				logrus.Infof("--> msgID:%v Packet:%v", c.MsgID, string(c.Packet))
 				msg := new(Ping)
-				id, err := network.UnmarshalUnregister(c.Packet, msg)
-				if err != nil {
+				if err := c.UnmarshalPacket(msg); err != nil {
 					logrus.Error(err)
 				}
+				id := c.MsgID
 				c.Send(2, &Pong{Data: "pong"})
 				logrus.Infof("--> msgID:%v ping: %v", id, msg.Data)
 				return
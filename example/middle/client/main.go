@@ -18,6 +18,9 @@ type Pong struct {
 	Data string
 }
 
+// count 收到的消息数量，类型安全的连接数据
+var count = network.NewSessionValue[int]("n")
+
 func main() {
 	logrus.SetLevel(logrus.TraceLevel)
 
@@ -25,7 +28,7 @@ func main() {
 	network.RegisterMiddle("test_middle1", func() network.Middle {
 		return &network.MiddleFunc{
 			AfterConnected: func(c *network.Context) {
-				c.Set("n", 0)
+				count.Set(c, 0)
 				logrus.Info("--> AfterConnected")
 				c.Send(1, &Ping{Data: "ping"})
 			},
@@ -39,7 +42,7 @@ func main() {
 	network.AddMiddle(func() network.Middle {
 		return &network.MiddleFunc{
 			AfterConnected: func(c *network.Context) {
-				c.Set("n", 0)
+				count.Set(c, 0)
 				logrus.Info("--> AfterConnected")
 				c.Send(1, &Ping{Data: "ping"})
 			},
@@ -49,8 +52,8 @@ func main() {
 		return new(TestMiddle)
 	})
 
-	network.SetHandlerFunc(2, &Pong{}, func(ctx *network.Context) {
-		logrus.Info("pong:", ctx.Msg.(*Pong).Data)
+	network.Handle(2, func(ctx *network.Context, msg *Pong) {
+		logrus.Info("pong:", msg.Data)
 		timer.AfterTimer(time.Second*3, func() {
 			ctx.Send(1, &Ping{Data: "ping"})
 		})
@@ -70,23 +73,23 @@ func (m *TestMiddle) Get(op network.Opportunity) func(c *network.Context) {
 
 	case network.BeforeReceived:
 		return func(c *network.Context) {
-			c.Set("n", c.GetInt("n")+1)
-			logrus.Infof("--> BeforeReceived MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, c.GetInt("n"))
+			count.Set(c, count.Value(c)+1)
+			logrus.Infof("--> BeforeReceived MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, count.Value(c))
 		}
 
 	case network.AfterReceived:
 		return func(c *network.Context) {
-			logrus.Infof("--> AfterReceived MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, c.GetInt("n"))
+			logrus.Infof("--> AfterReceived MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, count.Value(c))
 		}
 
 	case network.BeforeSend:
 		return func(c *network.Context) {
-			logrus.Infof("--> BeforeSend MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, c.GetInt("n"))
+			logrus.Infof("--> BeforeSend MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, count.Value(c))
 		}
 
 	case network.AfterSend:
 		return func(c *network.Context) {
-			logrus.Infof("--> AfterSend MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, c.GetInt("n"))
+			logrus.Infof("--> AfterSend MsgID:%v Msg:%v N:%v", c.MsgID, c.Msg, count.Value(c))
 		}
 	}
 	return nil
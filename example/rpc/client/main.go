@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube"
+	"github.com/skeletongo/cube/network"
+	"github.com/skeletongo/cube/timer"
+)
+
+type Add struct {
+	A, B int
+}
+
+type AddResult struct {
+	Sum int
+}
+
+func main() {
+	logrus.SetLevel(logrus.InfoLevel)
+
+	// 返回消息只需要注册消息类型
+	network.SetMessage(2, &AddResult{})
+
+	network.AddMiddle(func() network.Middle {
+		return &network.MiddleFunc{
+			AfterConnected: func(c *network.Context) {
+				call(c.Session, 1)
+			},
+		}
+	})
+	cube.Run()
+}
+
+func call(s *network.Session, n int) {
+	s.Call(1, &Add{A: n, B: n}, time.Second*3, func(c *network.Context, err error) {
+		if err != nil {
+			logrus.Errorf("call error: %v", err)
+			return
+		}
+		logrus.Infof("sum: %v", c.Msg.(*AddResult).Sum)
+		timer.AfterTimer(time.Second, func() {
+			call(s, n+1)
+		})
+	})
+}
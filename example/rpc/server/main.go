@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube"
+	"github.com/skeletongo/cube/network"
+)
+
+type Add struct {
+	A, B int
+}
+
+type AddResult struct {
+	Sum int
+}
+
+func main() {
+	logrus.SetLevel(logrus.InfoLevel)
+
+	network.SetHandlerFunc(1, &Add{}, func(c *network.Context) {
+		req := c.Msg.(*Add)
+		logrus.Infof("add: %v + %v", req.A, req.B)
+		c.Reply(2, &AddResult{Sum: req.A + req.B})
+	})
+	cube.Run()
+}
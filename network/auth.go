@@ -0,0 +1,295 @@
+package network
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube/base"
+	"github.com/skeletongo/cube/encoding"
+	"github.com/skeletongo/cube/module"
+)
+
+// DefaultAuthTimeout 建立连接后默认的认证期限
+const DefaultAuthTimeout = 10 * time.Second
+
+var (
+	ErrTokenInvalid = errors.New("auth token invalid")
+	ErrTokenExpired = errors.New("auth token expired")
+)
+
+// AuthState 连接认证状态
+type AuthState int32
+
+const (
+	AuthConnected      AuthState = iota // 建立连接，还没有收到认证消息
+	AuthAuthenticating                  // 正在验证凭证
+	AuthAuthenticated                   // 认证通过
+)
+
+func (a AuthState) String() string {
+	switch a {
+	case AuthConnected:
+		return "connected"
+	case AuthAuthenticating:
+		return "authenticating"
+	case AuthAuthenticated:
+		return "authenticated"
+	}
+	return "unknown"
+}
+
+// Identity 认证通过的用户身份
+type Identity struct {
+	UID    uint64            `json:"uid"`              // 用户ID
+	Expire int64             `json:"exp,omitempty"`    // 凭证过期时间，unix时间戳，单位秒，为0时不过期
+	Claims map[string]string `json:"claims,omitempty"` // 凭证中的其它数据
+}
+
+// AuthReq 认证消息，消息号为 AuthConfig.MsgID
+type AuthReq struct {
+	Token string
+}
+
+// AuthResp 认证结果，消息号和 AuthReq 相同，认证失败时返回后关闭连接
+type AuthResp struct {
+	UID uint64
+	Err string
+}
+
+// AuthConfig 认证过滤器配置，FilterChain 中有 "auth" 时生效
+// 认证通过之前只处理 Whitelist 中的消息，其它消息直接丢弃，包括网关转发的消息
+type AuthConfig struct {
+	MsgID     uint32        // 认证消息号，消息结构为 AuthReq，为0时由应用处理自己的认证消息并调用 Session.Authenticate
+	Whitelist []uint32      // 认证通过之前允许处理的消息号
+	Timeout   time.Duration // 建立连接后没有在这个时间内通过认证时关闭连接，单位秒，默认10秒
+	Verifier  string        // 凭证验证方式，默认 "hmac"，自定义验证方式通过 RegisterVerifier 注册
+	Secret    string        // "hmac" 验证方式的秘钥
+
+	whitelist map[uint32]struct{}
+	verifier  Verifier
+}
+
+func (a *AuthConfig) init(config *ServiceConfig) (err error) {
+	if a.Timeout > 0 {
+		a.Timeout *= time.Second
+	} else {
+		a.Timeout = DefaultAuthTimeout
+	}
+	a.whitelist = make(map[uint32]struct{}, len(a.Whitelist))
+	for _, v := range a.Whitelist {
+		a.whitelist[v] = struct{}{}
+	}
+	if a.MsgID == 0 {
+		return nil
+	}
+	if a.Verifier == "" {
+		a.Verifier = "hmac"
+	}
+	f, ok := verifierCreators[a.Verifier]
+	if !ok {
+		return fmt.Errorf("verifier not found: %s", a.Verifier)
+	}
+	a.verifier, err = f(config)
+	return err
+}
+
+// Verifier 认证凭证验证
+type Verifier interface {
+	// Verify 验证凭证并返回用户身份，在单独的协程中调用，可以访问外部服务
+	Verify(token string) (*Identity, error)
+}
+
+var verifierCreators = make(map[string]func(config *ServiceConfig) (Verifier, error))
+
+// RegisterVerifier 注册凭证验证方式
+// name 名称，对应配置中的 AuthConfig.Verifier
+// f 创建方法，每个服务调用一次
+func RegisterVerifier(name string, f func(config *ServiceConfig) (Verifier, error)) {
+	verifierCreators[name] = f
+}
+
+// HMACVerifier 使用本地秘钥验证 SignToken 生成的凭证
+type HMACVerifier struct {
+	Secret []byte
+}
+
+func (h *HMACVerifier) Verify(token string) (*Identity, error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sum, signature(h.Secret, payload)) {
+		return nil, ErrTokenInvalid
+	}
+	id := new(Identity)
+	if err = json.Unmarshal(payload, id); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if id.Expire > 0 && time.Now().Unix() >= id.Expire {
+		return nil, ErrTokenExpired
+	}
+	return id, nil
+}
+
+// SignToken 生成 HMACVerifier 验证的凭证，格式为 base64(身份数据).base64(签名)
+func SignToken(secret []byte, id *Identity) (string, error) {
+	payload, err := json.Marshal(id)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signature(secret, payload)), nil
+}
+
+func signature(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+var authHooks []func(s *Session, id *Identity)
+
+// OnAuth 注册认证通过的回调方法
+// 回调方法在module节点上执行，需要在网络服务启动前注册
+func OnAuth(f func(s *Session, id *Identity)) {
+	authHooks = append(authHooks, f)
+}
+
+// sessionAuth 连接认证状态
+type sessionAuth struct {
+	required bool      // 是否需要认证，启用 "auth" 过滤器时为true
+	state    AuthState // 认证状态
+	identity *Identity // 认证通过的用户身份
+	deadline time.Time // 认证期限
+}
+
+// AuthState 获取连接认证状态
+func (s *Session) AuthState() AuthState {
+	return s.auth.state
+}
+
+// Identity 获取认证通过的用户身份，没有认证时为nil
+func (s *Session) Identity() *Identity {
+	return s.auth.identity
+}
+
+// Authenticate 设置连接认证通过，应用自己处理认证消息时调用
+// 用户ID不为0时绑定用户，重复登录被拒绝时返回 ErrDuplicateLogin，见 BindUser
+// 线程不安全，必须在module节点上执行
+func (s *Session) Authenticate(id *Identity) error {
+	if id.UID != 0 {
+		if err := BindUser(s, id.UID); err != nil {
+			return err
+		}
+	}
+	s.auth.state = AuthAuthenticated
+	s.auth.identity = id
+	for _, f := range authHooks {
+		f(s, id)
+	}
+	return nil
+}
+
+// authorized 认证通过之前是否允许处理消息，不允许时处理认证消息或者丢弃
+// 返回是否继续处理
+func (s *Session) authorized(head *MsgHead, et encoding.EncodeType, data []byte) bool {
+	if s.allowed(head.MsgID) {
+		return true
+	}
+	if conf := &s.SC.Auth; conf.MsgID != 0 && head.MsgID == conf.MsgID {
+		s.verify(head, et, data)
+		return false
+	}
+	log.WithField("SessionInfo", s).Tracef("drop message before auth: %d", head.MsgID)
+	return false
+}
+
+// allowed 是否允许处理消息，认证通过之后或者消息号在白名单中
+func (s *Session) allowed(msgID uint32) bool {
+	if !s.auth.required || s.auth.state == AuthAuthenticated {
+		return true
+	}
+	_, ok := s.SC.Auth.whitelist[msgID]
+	return ok
+}
+
+// verify 在单独的协程中验证凭证，完成后回到module节点上处理结果
+func (s *Session) verify(head *MsgHead, et encoding.EncodeType, data []byte) {
+	if s.auth.state != AuthConnected {
+		return
+	}
+	req := new(AuthReq)
+	if err := unmarshalMsg(head.MsgID, et, data, req); err != nil {
+		log.WithField("SessionInfo", s).Errorf("auth unmarshal error: %v", err)
+		_ = s.CloseWithReason(CloseAuthFailed)
+		return
+	}
+	s.auth.state = AuthAuthenticating
+
+	resp := &MsgHead{MsgID: head.MsgID}
+	if head.HasFlag(FlagRequest) {
+		resp.Flags = FlagResponse
+		resp.Seq = head.Seq
+	}
+	verifier := s.SC.Auth.verifier
+	go func() {
+		id, err := verifier.Verify(req.Token)
+		if err == nil && id == nil {
+			err = ErrTokenInvalid
+		}
+		module.Obj.SendFunc(func(o *base.Object) {
+			if s.CloseReason() != CloseNone {
+				return
+			}
+			if err != nil {
+				log.WithField("SessionInfo", s).Warningf("auth failed: %v", err)
+				_ = s.sendHead(resp, &AuthResp{Err: err.Error()})
+				s.closeAfterFlush(CloseAuthFailed)
+				return
+			}
+			if err = s.Authenticate(id); err != nil {
+				// 拒绝重复登录，已经发送踢下线通知并关闭连接
+				s.auth.state = AuthConnected
+				return
+			}
+			_ = s.sendHead(resp, &AuthResp{UID: id.UID})
+		})
+	}()
+}
+
+// authTimeout 是否超过认证期限
+func (s *Session) authTimeout(now time.Time) bool {
+	return s.auth.required && s.auth.state != AuthAuthenticated && now.After(s.auth.deadline)
+}
+
+func init() {
+	RegisterVerifier("hmac", func(config *ServiceConfig) (Verifier, error) {
+		if config.Auth.Secret == "" {
+			return nil, errors.New("hmac verifier secret required")
+		}
+		return &HMACVerifier{Secret: []byte(config.Auth.Secret)}, nil
+	})
+
+	RegisterFilter("auth", func() Filter {
+		return &FilterFunc{
+			AfterConnected: func(c *Context) bool {
+				c.auth.required = true
+				c.auth.deadline = time.Now().Add(c.SC.Auth.Timeout)
+				return true
+			},
+		}
+	})
+}
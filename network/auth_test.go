@@ -0,0 +1,198 @@
+package network
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAuthMsgID  = 50
+	testAuthSecret = "secret"
+)
+
+func TestSignToken(t *testing.T) {
+	v := &HMACVerifier{Secret: []byte(testAuthSecret)}
+	sign := func(secret string, id *Identity) string {
+		token, err := SignToken([]byte(secret), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(testAuthSecret, &Identity{UID: 1, Claims: map[string]string{"role": "admin"}})
+	other := sign(testAuthSecret, &Identity{UID: 2})
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", valid, nil},
+		{"not expired", sign(testAuthSecret, &Identity{UID: 1, Expire: time.Now().Add(time.Hour).Unix()}), nil},
+		{"expired", sign(testAuthSecret, &Identity{UID: 1, Expire: time.Now().Add(-time.Second).Unix()}), ErrTokenExpired},
+		{"wrong secret", sign("other", &Identity{UID: 1}), ErrTokenInvalid},
+		{"tampered payload", other[:strings.IndexByte(other, '.')] + valid[strings.IndexByte(valid, '.'):], ErrTokenInvalid},
+		{"tampered signature", valid[:len(valid)-2] + "AA", ErrTokenInvalid},
+		{"no signature", valid[:strings.IndexByte(valid, '.')], ErrTokenInvalid},
+		{"bad encoding", "!." + valid[strings.IndexByte(valid, '.')+1:], ErrTokenInvalid},
+		{"empty", "", ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := v.Verify(tt.token)
+			if err != tt.err {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+			if err == nil && id.UID != 1 {
+				t.Fatalf("uid %d", id.UID)
+			}
+		})
+	}
+	if id, _ := v.Verify(valid); id.Claims["role"] != "admin" {
+		t.Fatalf("claims %v", id.Claims)
+	}
+}
+
+// authTest 启用认证过滤器的管道服务端和客户端，认证之前只处理消息2
+type authTest struct {
+	*pipeTest
+	sc      *ServiceConfig // 服务端配置
+	handled []uint32       // 服务端处理的消息号
+	resp    []*AuthResp    // 服务端返回的认证结果
+}
+
+func newAuthTest(t *testing.T, setup func(server, client *ServiceConfig)) *authTest {
+	testModule(t)
+	a := &authTest{}
+	h := testMsgHandler(t)
+	for _, id := range []uint32{1, 2} {
+		id := id
+		h.SetHandlerFunc(id, new(pipeMsg), func(c *Context) { a.handled = append(a.handled, id) })
+	}
+
+	server, client := testConfigs(t)
+	server.Auth = AuthConfig{MsgID: testAuthMsgID, Whitelist: []uint32{2}, Secret: testAuthSecret}
+	if setup != nil {
+		setup(server, client)
+	}
+	a.sc = server
+	a.pipeTest = newPipeTest(t, server, client)
+	testFilter(server, gFilterMgr.filterCreators["auth"]())
+	testFilter(server, &FilterFunc{BeforeSend: func(c *Context) bool {
+		if r, ok := c.Msg.(*AuthResp); ok {
+			a.resp = append(a.resp, r)
+		}
+		return true
+	}})
+	testUsers(t, server)
+	return a
+}
+
+// login 客户端发送认证消息
+func (a *authTest) login(cs *Session, id *Identity) {
+	token, err := SignToken([]byte(testAuthSecret), id)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	testOnModule(func() { _ = cs.sendHead(&MsgHead{MsgID: testAuthMsgID}, &AuthReq{Token: token}) })
+}
+
+// send 客户端依次发送消息，最后发送白名单中的消息2，等待服务端处理
+func (a *authTest) send(cs *Session, ids ...uint32) {
+	n := len(a.handled)
+	testOnModule(func() {
+		for _, id := range ids {
+			cs.Send(id, &pipeMsg{})
+		}
+		cs.Send(2, &pipeMsg{})
+	})
+	a.wait("handled", func() bool { return len(a.handled) > n && a.handled[len(a.handled)-1] == 2 })
+}
+
+func TestAuth(t *testing.T) {
+	a := newAuthTest(t, nil)
+	ss, cs := a.connected()
+
+	// 认证之前只处理白名单中的消息，其它消息直接丢弃
+	a.send(cs, 1)
+	if len(a.handled) != 1 || ss.AuthState() != AuthConnected || ss.CloseReason() != CloseNone {
+		t.Fatalf("handled %v state %v", a.handled, ss.AuthState())
+	}
+
+	a.login(cs, &Identity{UID: 7})
+	a.wait("auth resp", func() bool { return len(a.resp) > 0 })
+	if r := a.resp[0]; r.Err != "" || r.UID != 7 {
+		t.Fatalf("resp %+v", r)
+	}
+	if ss.AuthState() != AuthAuthenticated || ss.Identity().UID != 7 || ss.UID() != 7 {
+		t.Fatalf("state %v identity %+v", ss.AuthState(), ss.Identity())
+	}
+
+	// 认证通过后处理所有消息
+	a.send(cs, 1)
+	if len(a.handled) != 3 || a.handled[1] != 1 {
+		t.Fatalf("handled %v", a.handled)
+	}
+}
+
+func TestAuthFailed(t *testing.T) {
+	a := newAuthTest(t, nil)
+	ss, cs := a.connected()
+	a.login(cs, &Identity{UID: 7, Expire: time.Now().Add(-time.Second).Unix()})
+
+	// 返回认证结果后关闭连接
+	a.wait("closed", func() bool { return len(a.server.sessions) == 0 && len(a.resp) > 0 })
+	if r := a.resp[0]; r.Err != ErrTokenExpired.Error() || r.UID != 0 {
+		t.Fatalf("resp %+v", r)
+	}
+	if ss.CloseReason() != CloseAuthFailed || ss.AuthState() == AuthAuthenticated || ss.UID() != 0 {
+		t.Fatalf("closed %v state %v", ss.CloseReason(), ss.AuthState())
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	a := newAuthTest(t, nil)
+	// 配置的单位是秒，初始化之后再改成毫秒级
+	a.sc.Auth.Timeout = 20 * time.Millisecond
+	ss, _ := a.connected()
+	start := time.Now()
+	a.wait("closed", func() bool { return len(a.server.sessions) == 0 })
+	if ss.CloseReason() != CloseAuthTimeout || time.Since(start) >= time.Second {
+		t.Fatalf("closed %v after %v", ss.CloseReason(), time.Since(start))
+	}
+}
+
+func TestAuthDuplicateReject(t *testing.T) {
+	a := newAuthTest(t, func(server, client *ServiceConfig) {
+		server.DuplicateLogin = DuplicateReject
+		client.ClientNum = 2
+	})
+	var first, second *Session
+	a.wait("connected", func() bool { return len(a.server.sessions) == 2 && len(a.client.sessions) == 2 })
+	for s := range a.client.sessions {
+		if first == nil {
+			first = s
+		} else {
+			second = s
+		}
+	}
+	a.login(first, &Identity{UID: 7})
+	a.wait("first auth", func() bool { return len(a.resp) == 1 })
+	ss := SessionByUser(7)
+
+	// 拒绝后恢复为未认证状态并关闭连接，先登录的连接不受影响
+	var rejected *Session
+	for s := range a.server.sessions {
+		if s != ss {
+			rejected = s
+		}
+	}
+	a.login(second, &Identity{UID: 7})
+	a.wait("rejected", func() bool { return len(a.server.sessions) == 1 })
+	if rejected.AuthState() != AuthConnected || rejected.CloseReason() != CloseKicked {
+		t.Fatalf("rejected state %v closed %v", rejected.AuthState(), rejected.CloseReason())
+	}
+	if SessionByUser(7) != ss || ss.AuthState() != AuthAuthenticated || ss.CloseReason() != CloseNone {
+		t.Fatal("first login affected")
+	}
+}
@@ -0,0 +1,424 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 发送或接收队列已满时的处理策略，见 ServiceConfig.SendPolicy ServiceConfig.RecvPolicy
+const (
+	PolicyClose      = "close"       // 关闭连接
+	PolicyBlock      = "block"       // 等待队列有空位，超时后关闭连接
+	PolicyDropOldest = "drop_oldest" // 丢弃队列中最早的消息，用于发送队列时需要关闭分片（ServiceConfig.FragmentSize 小于0）
+	PolicyDropNewest = "drop_newest" // 丢弃新消息
+	PolicySpill      = "spill"       // 放入不限长度的溢出队列，长度超过 ServiceConfig.HighWater 时通知 OnHighWater
+)
+
+// DefaultSendTimeout 发送策略为 PolicyBlock 时默认的等待时间
+const DefaultSendTimeout = 100 * time.Millisecond
+
+// ErrMsgDropped 队列已满，消息按策略被丢弃
+var ErrMsgDropped = errors.New("message dropped: channel full")
+
+func checkPolicy(policy string) error {
+	switch policy {
+	case PolicyClose, PolicyBlock, PolicyDropOldest, PolicyDropNewest, PolicySpill:
+		return nil
+	}
+	return fmt.Errorf("queue policy %s not found", policy)
+}
+
+// QueueStats 连接的队列状态
+type QueueStats struct {
+	SendLen     int   // 发送队列中的数据包数量
+	SendSpill   int   // 发送溢出队列中的数据包数量
+	SendDropped int64 // 发送队列已满时丢弃的数据包数量
+	RecvLen     int   // 接收队列中的数据包数量
+	RecvSpill   int   // 接收溢出队列中的数据包数量
+	RecvDropped int64 // 接收队列已满时丢弃的数据包数量
+}
+
+// HighWaterEvent 溢出队列超过高水位
+type HighWaterEvent struct {
+	Session *Session
+	Send    bool // true 发送队列，false 接收队列
+	Len     int  // 溢出队列长度
+}
+
+var highWaterHooks []func(e *HighWaterEvent)
+
+// OnHighWater 注册溢出队列超过高水位的回调方法，每次超过高水位只通知一次，溢出队列清空后重新计算
+// 回调方法在module节点上执行，需要在网络服务启动前注册
+func OnHighWater(f func(e *HighWaterEvent)) {
+	highWaterHooks = append(highWaterHooks, f)
+}
+
+// QueueStats 获取队列状态，需要在module节点上调用
+func (s *Session) QueueStats() QueueStats {
+	s.recvMu.Lock()
+	recvSpill := len(s.recvSpill)
+	s.recvMu.Unlock()
+	return QueueStats{
+		SendLen:     len(s.send),
+		SendSpill:   len(s.sendSpill),
+		SendDropped: atomic.LoadInt64(&s.sendDropped),
+		RecvLen:     len(s.recv),
+		RecvSpill:   recvSpill,
+		RecvDropped: atomic.LoadInt64(&s.recvDropped),
+	}
+}
+
+func releasePacks(packs []*sendPack) {
+	for _, v := range packs {
+		if v != nil {
+			v.release()
+		}
+	}
+}
+
+// push 数据包放入发送队列
+// 单个数据包的消息在队列空间不足时按 ServiceConfig.SendPolicy 处理
+// 分片消息不丢弃也不因为队列已满关闭连接，能放入发送队列的分片先放入，剩余的放入溢出队列，由 flushSpill 逐步放入发送队列，
+// 溢出队列清空前后续的消息也放入溢出队列，保证消息顺序
+func (s *Session) push(packs ...*sendPack) error {
+	for i := 0; i < len(packs)-1; i++ {
+		packs[i].more = true
+	}
+
+	if r := s.resume; r != nil && r.old != nil && !packs[0].ctrl {
+		// 客户端新连接收到恢复结果之后再发送
+		r.held = append(r.held, packs)
+		return nil
+	}
+	if r := s.resume; r != nil && !packs[0].ctrl && atomic.LoadInt32(&s.final) == 0 {
+		r.track(packs, s.SC.Resume.MaxPending)
+	}
+
+	if s.flushing {
+		// 已经放入结束标记，发送协程不会再发送
+		releasePacks(packs)
+		return ErrSessionClosed
+	}
+
+	select {
+	case <-s.closeSign:
+		if s.resume != nil && atomic.LoadInt32(&s.final) == 0 {
+			// 连接因为网络原因断开，恢复后重发
+			releasePacks(packs)
+			return nil
+		}
+		log.WithField("SessionInfo", s).Trace("session closed")
+		releasePacks(packs)
+		return ErrSessionClosed
+	default:
+	}
+
+	if len(s.sendSpill) > 0 && s.SC.SendPolicy != PolicySpill {
+		// 恢复连接时重发的数据包还没有全部放入发送队列
+		s.sendSpill = append(s.sendSpill, packs...)
+		return nil
+	}
+
+	if len(s.sendSpill) == 0 && cap(s.send)-len(s.send) >= len(packs) {
+		return s.enqueue(packs)
+	}
+
+	if len(packs) > 1 && s.SC.SendPolicy != PolicyBlock {
+		n := 0
+		if len(s.sendSpill) == 0 {
+			n = cap(s.send) - len(s.send)
+		}
+		if err := s.enqueue(packs[:n]); err != nil {
+			releasePacks(packs[n:])
+			return err
+		}
+		s.sendSpill = append(s.sendSpill, packs[n:]...)
+		return nil
+	}
+
+	switch s.SC.SendPolicy {
+	case PolicySpill:
+		s.sendSpill = append(s.sendSpill, packs...)
+		return nil
+
+	case PolicyDropNewest:
+		releasePacks(packs)
+		atomic.AddInt64(&s.sendDropped, int64(len(packs)))
+		return ErrMsgDropped
+
+	case PolicyDropOldest:
+		for cap(s.send)-len(s.send) < len(packs) {
+			if !s.dropOldest() {
+				releasePacks(packs)
+				return ErrSessionClosed
+			}
+		}
+		return s.enqueue(packs)
+
+	case PolicyBlock:
+		// 每个数据包最多等待 SendTimeout，分片消息在发送协程持续取出数据时不会超时
+		for i, v := range packs {
+			if err := s.pushWait(v); err != nil {
+				releasePacks(packs[i:])
+				return err
+			}
+		}
+		return nil
+	}
+
+	log.WithField("SessionInfo", s).Error("close conn: send channel full")
+	releasePacks(packs)
+	_ = s.CloseWithReason(CloseChannelFull)
+	return ErrSessionClosed
+}
+
+// pushWait 数据包放入发送队列，队列已满时最多等待 SendTimeout，超时后关闭连接
+func (s *Session) pushWait(v *sendPack) error {
+	select {
+	case s.send <- v:
+		return nil
+	default:
+	}
+	t := time.NewTimer(s.SC.SendTimeout)
+	defer t.Stop()
+	select {
+	case s.send <- v:
+		return nil
+	case <-s.closeSign:
+		return ErrSessionClosed
+	case <-t.C:
+		log.WithField("SessionInfo", s).Error("close conn: send channel full")
+		_ = s.CloseWithReason(CloseChannelFull)
+		return ErrSessionClosed
+	}
+}
+
+// enqueue 数据包放入发送队列，调用前已经确认队列空间足够
+func (s *Session) enqueue(packs []*sendPack) error {
+	for i, v := range packs {
+		select {
+		case s.send <- v:
+		default:
+			// 连接已经关闭，发送队列中放入了结束标记
+			releasePacks(packs[i:])
+			return ErrSessionClosed
+		}
+	}
+	return nil
+}
+
+// dropOldest 丢弃发送队列中最早的一个消息
+// 策略为 PolicyDropOldest 时不分片，队列中的每个数据包都是完整的消息，不会丢弃发送协程正在发送的消息的剩余分片
+// 返回false表示连接已经关闭
+func (s *Session) dropOldest() bool {
+	select {
+	case v := <-s.send:
+		if v == nil {
+			// 结束标记放回去，发送协程会在队列为空之前取到它
+			select {
+			case s.send <- nil:
+			default:
+			}
+			return false
+		}
+		v.release()
+		atomic.AddInt64(&s.sendDropped, 1)
+	default:
+	}
+	return true
+}
+
+// flushSpill 溢出队列中的数据包放入发送队列，在module节点上调用
+func (s *Session) flushSpill() {
+	n := 0
+	for n < len(s.sendSpill) {
+		select {
+		case s.send <- s.sendSpill[n]:
+			s.sendSpill[n] = nil
+			n++
+			continue
+		default:
+		}
+		break
+	}
+	if n == 0 {
+		return
+	}
+	s.sendSpill = s.sendSpill[n:]
+	if len(s.sendSpill) == 0 {
+		s.sendSpill = nil
+		s.sendHigh = false
+	}
+}
+
+// pushRecv 收到的数据包放入接收队列，在读取数据的协程中调用
+// 队列空间不足时按 ServiceConfig.RecvPolicy 处理，返回false时停止读取数据
+func (s *Session) pushRecv(data []byte) bool {
+	s = s.resolve()
+	if s.SC.RecvPolicy == PolicySpill {
+		// 溢出队列不为空时新数据也放入溢出队列，保证消息顺序
+		s.recvMu.Lock()
+		if len(s.recvSpill) > 0 {
+			s.recvSpill = append(s.recvSpill, data)
+			s.recvMu.Unlock()
+			return true
+		}
+		s.recvMu.Unlock()
+	}
+
+	select {
+	case s.recv <- data:
+		return true
+	default:
+	}
+
+	switch s.SC.RecvPolicy {
+	case PolicySpill:
+		s.recvMu.Lock()
+		s.recvSpill = append(s.recvSpill, data)
+		s.recvMu.Unlock()
+		return true
+
+	case PolicyDropNewest:
+		putBuffer(bytes.NewBuffer(data))
+		atomic.AddInt64(&s.recvDropped, 1)
+		return true
+
+	case PolicyDropOldest:
+		// 接收队列中的数据还没有解析，丢弃分片消息中的一片会导致重组失败而关闭连接
+		for {
+			select {
+			case s.recv <- data:
+				return true
+			case <-s.closeSign:
+				putBuffer(bytes.NewBuffer(data))
+				return false
+			default:
+			}
+			select {
+			case v := <-s.recv:
+				putBuffer(bytes.NewBuffer(v))
+				atomic.AddInt64(&s.recvDropped, 1)
+			default:
+			}
+		}
+
+	case PolicyClose:
+		log.WithField("SessionInfo", s).Error("close conn: recv channel full")
+		putBuffer(bytes.NewBuffer(data))
+		_ = s.CloseWithReason(CloseChannelFull)
+		return false
+	}
+
+	// PolicyBlock
+	var timeout <-chan time.Time
+	if s.SC.RecvTimeout > 0 {
+		t := time.NewTimer(s.SC.RecvTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case s.recv <- data:
+		return true
+	case <-s.closeSign:
+		putBuffer(bytes.NewBuffer(data))
+		return false
+	case <-timeout:
+		log.WithField("SessionInfo", s).Error("close conn: recv channel full")
+		putBuffer(bytes.NewBuffer(data))
+		_ = s.CloseWithReason(CloseChannelFull)
+		return false
+	}
+}
+
+// popRecv 取出接收队列中的数据包，接收队列为空时从溢出队列中取，在module节点上调用
+func (s *Session) popRecv() ([]byte, bool) {
+	select {
+	case v := <-s.recv:
+		return v, true
+	default:
+	}
+	if s.SC.RecvPolicy != PolicySpill {
+		return nil, false
+	}
+	s.recvMu.Lock()
+	defer s.recvMu.Unlock()
+	if len(s.recvSpill) == 0 {
+		return nil, false
+	}
+	v := s.recvSpill[0]
+	s.recvSpill[0] = nil
+	s.recvSpill = s.recvSpill[1:]
+	if len(s.recvSpill) == 0 {
+		s.recvSpill = nil
+		s.recvHigh = false
+	}
+	return v, true
+}
+
+// checkHighWater 溢出队列超过高水位时通知，在module节点上调用
+func (s *Session) checkHighWater() {
+	if n := len(s.sendSpill); !s.sendHigh && n > s.SC.HighWater {
+		s.sendHigh = true
+		s.fireHighWater(true, n)
+	}
+	if s.SC.RecvPolicy != PolicySpill {
+		return
+	}
+	s.recvMu.Lock()
+	n := len(s.recvSpill)
+	fire := !s.recvHigh && n > s.SC.HighWater
+	if fire {
+		s.recvHigh = true
+	}
+	s.recvMu.Unlock()
+	if fire {
+		s.fireHighWater(false, n)
+	}
+}
+
+func (s *Session) fireHighWater(send bool, n int) {
+	log.WithField("SessionInfo", s).Warningf("queue high water: send %v, len %d", send, n)
+	e := &HighWaterEvent{Session: s, Send: send, Len: n}
+	for _, f := range highWaterHooks {
+		f(e)
+	}
+}
+
+// drainSend 释放发送队列中的数据包
+func (s *Session) drainSend() {
+	for {
+		select {
+		case v := <-s.send:
+			if v != nil {
+				v.release()
+			}
+			continue
+		default:
+		}
+		return
+	}
+}
+
+// releaseSpill 连接关闭后释放溢出队列中的数据
+func (s *Session) releaseSpill() {
+	releasePacks(s.sendSpill)
+	s.sendSpill = nil
+	select {
+	case <-s.sendDone:
+		// 发送协程已经退出，发送队列中剩余的数据包不会再发送
+		s.drainSend()
+	default:
+	}
+	s.recvMu.Lock()
+	for _, v := range s.recvSpill {
+		putBuffer(bytes.NewBuffer(v))
+	}
+	s.recvSpill = nil
+	s.recvMu.Unlock()
+}
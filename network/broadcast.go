@@ -0,0 +1,75 @@
+package network
+
+import (
+	"errors"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube/encoding"
+)
+
+// Broadcast 给多个连接发送同一个消息
+// 同一个服务的连接只序列化及封包一次，所有连接的发送队列共享同一份数据，最后一个连接发送完成后归还缓存
+// 不经过 BeforeSend 及 AfterSend 过滤器和中间件，需要按连接处理的消息使用 Session.Send
+// 线程不安全，必须在module节点上执行
+func Broadcast(sessions []*Session, msgID uint32, msg interface{}) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.WithField("msgID", msgID).Error("message pointer required")
+		return errors.New("message pointer required")
+	}
+	et, data, err := encodeMsg(msg)
+	if err != nil {
+		log.WithField("msgID", msgID).Errorf("broadcast message error: %v", err)
+		return err
+	}
+
+	// 通常所有连接属于同一个服务，不需要分组
+	sc := sessions[0].SC
+	same := true
+	for _, s := range sessions {
+		if s.SC != sc {
+			same = false
+			break
+		}
+	}
+	if same {
+		return broadcast(sc, sessions, msgID, et, data)
+	}
+
+	groups := make(map[*ServiceConfig][]*Session)
+	for _, s := range sessions {
+		groups[s.SC] = append(groups[s.SC], s)
+	}
+	for sc, v := range groups {
+		if e := broadcast(sc, v, msgID, et, data); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// broadcast 按服务的封包规则封包一次，所有连接共享封包后的数据
+func broadcast(sc *ServiceConfig, sessions []*Session, msgID uint32, et encoding.EncodeType, data []byte) error {
+	pkgs, err := sc.pack(&MsgHead{MsgID: msgID}, et, data)
+	if err != nil {
+		log.WithField("msgID", msgID).Errorf("broadcast message error: %v", err)
+		return err
+	}
+	refs := make([]int32, len(pkgs))
+	for i := range refs {
+		refs[i] = int32(len(sessions))
+	}
+	for _, s := range sessions {
+		packs := make([]*sendPack, len(pkgs))
+		for i, v := range pkgs {
+			packs[i] = &sendPack{data: v, ref: &refs[i]}
+		}
+		_ = s.push(packs...)
+	}
+	return nil
+}
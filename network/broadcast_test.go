@@ -0,0 +1,60 @@
+package network
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	testMsgHandler(t)
+	sc, _ := testConfigs(t)
+	if err := sc.init(); err != nil {
+		t.Fatal(err)
+	}
+	sessions := make([]*Session, 3)
+	recv := make([]<-chan int, len(sessions))
+	for i := range sessions {
+		sessions[i], recv[i] = testPipeSession(t, sc)
+	}
+	if err := Broadcast(sessions, 1, &pipeMsg{Text: "broadcast"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 所有连接共享同一份封包后的数据
+	packs := make([]*sendPack, len(sessions))
+	for i, s := range sessions {
+		packs[i] = <-s.send
+		s.send <- packs[i]
+	}
+	for _, v := range packs[1:] {
+		if &v.data[0] != &packs[0].data[0] || v.ref != packs[0].ref {
+			t.Fatal("broadcast packed more than once")
+		}
+	}
+	ref := packs[0].ref
+	if n := atomic.LoadInt32(ref); n != int32(len(sessions)) {
+		t.Fatalf("ref %d, want %d", n, len(sessions))
+	}
+
+	// 最后一个连接在发送前关闭，发送失败后释放
+	last := sessions[len(sessions)-1]
+	_ = last.Close()
+	for _, s := range sessions {
+		go s.sendMsg()
+	}
+	for i, s := range sessions[:len(sessions)-1] {
+		s.closeAfterFlush(CloseNormal)
+		<-s.sendDone
+		if n := <-recv[i]; n != 1 {
+			t.Fatalf("session %d received %d", i, n)
+		}
+	}
+	<-last.sendDone
+	if n := <-recv[len(sessions)-1]; n != 0 {
+		t.Fatalf("closed session received %d", n)
+	}
+	// 所有连接发送完成后只归还一次
+	if n := atomic.LoadInt32(ref); n != 0 {
+		t.Fatalf("ref %d after all sessions written", n)
+	}
+}
@@ -0,0 +1,275 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Cipher 自定义加密传输层，用于不能使用TLS的连接，见 ServiceConfig.Cipher
+// 同一个服务的所有连接共用一个实例，需要并发安全
+type Cipher interface {
+	// Client 包装连接发起方的连接
+	Client(conn net.Conn) net.Conn
+
+	// Server 包装服务端接收的连接
+	Server(conn net.Conn) net.Conn
+}
+
+var cipherCreators = make(map[string]func(config *ServiceConfig) (Cipher, error))
+
+// RegisterCipher 注册加密传输层
+// name 名称，对应配置中的 ServiceConfig.Cipher
+// f 创建方法，每个服务调用一次
+func RegisterCipher(name string, f func(config *ServiceConfig) (Cipher, error)) {
+	cipherCreators[name] = f
+}
+
+func newCipher(config *ServiceConfig) (Cipher, error) {
+	if config.Cipher == "" {
+		return nil, nil
+	}
+	f, ok := cipherCreators[config.Cipher]
+	if !ok {
+		return nil, fmt.Errorf("cipher not found: %s", config.Cipher)
+	}
+	return f(config)
+}
+
+const (
+	maxRecordLen            = 64 * 1024        // 每条加密记录最大明文长度
+	defaultHandshakeTimeout = 10 * time.Second // 默认的握手超时时间，见 ServiceConfig.HandshakeTimeout
+)
+
+// AESGCM 内置的加密传输层
+// 连接建立后双方先交换 X25519 公钥协商秘钥，之后每次写入的数据加密成一条记录
+//
+// 记录结构
+// ------------------
+// | len | ciphertext |
+// ------------------
+// len 密文长度，4个字节大端序
+// ciphertext AES-256-GCM 密文，nonce 为每个方向各自递增的序号
+//
+// 密钥协商本身不能防止中间人攻击，配置预共享秘钥 Key 后，秘钥不一致的连接在读取第一条记录时失败
+// 握手在第一次读写时进行，握手期间使用 Timeout 作为读写期限，结束后恢复调用方设置的读写期限
+type AESGCM struct {
+	Key     []byte        // 预共享秘钥，可以为空
+	Timeout time.Duration // 握手超时时间
+}
+
+func (a *AESGCM) Client(conn net.Conn) net.Conn {
+	return &aesgcmConn{Conn: conn, cfg: a, isClient: true}
+}
+
+func (a *AESGCM) Server(conn net.Conn) net.Conn {
+	return &aesgcmConn{Conn: conn, cfg: a}
+}
+
+type aesgcmConn struct {
+	net.Conn
+	cfg      *AESGCM
+	isClient bool
+
+	once sync.Once
+	err  error // 握手错误
+
+	mu          sync.Mutex
+	handshaking bool      // 正在握手，调用方设置的读写期限在握手结束后生效
+	rdl, wdl    time.Time // 调用方设置的读写期限
+
+	reader cipher.AEAD
+	writer cipher.AEAD
+	rseq   uint64
+	wseq   uint64
+	rbuf   []byte // 已解密还没有读取的数据
+}
+
+// handshake 第一次读写时握手
+func (c *aesgcmConn) handshake() error {
+	c.once.Do(func() {
+		c.err = c.doHandshake()
+	})
+	return c.err
+}
+
+func (c *aesgcmConn) doHandshake() error {
+	timeout := c.cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	c.mu.Lock()
+	c.handshaking = true
+	c.Conn.SetDeadline(time.Now().Add(timeout))
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.handshaking = false
+		c.Conn.SetReadDeadline(c.rdl)
+		c.Conn.SetWriteDeadline(c.wdl)
+		c.mu.Unlock()
+	}()
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	local := priv.PublicKey().Bytes()
+	// 同时发送和接收公钥，同步的连接（如 net.Pipe）也不会互相等待
+	werr := make(chan error, 1)
+	go func() {
+		_, err := c.Conn.Write(local)
+		werr <- err
+	}()
+	remote := make([]byte, len(local))
+	if _, err = io.ReadFull(c.Conn, remote); err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(remote)
+	if err != nil {
+		return err
+	}
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return err
+	}
+
+	clientPub, serverPub := local, remote
+	if !c.isClient {
+		clientPub, serverPub = remote, local
+	}
+	c2s, err := c.newAEAD(secret, clientPub, serverPub, "c2s")
+	if err != nil {
+		return err
+	}
+	s2c, err := c.newAEAD(secret, clientPub, serverPub, "s2c")
+	if err != nil {
+		return err
+	}
+	if c.isClient {
+		c.writer, c.reader = c2s, s2c
+	} else {
+		c.writer, c.reader = s2c, c2s
+	}
+	return nil
+}
+
+func (c *aesgcmConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rdl, c.wdl = t, t
+	if c.handshaking {
+		return nil
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *aesgcmConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rdl = t
+	if c.handshaking {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *aesgcmConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wdl = t
+	if c.handshaking {
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *aesgcmConn) newAEAD(secret, clientPub, serverPub []byte, label string) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(secret)
+	h.Write(c.cfg.Key)
+	h.Write(clientPub)
+	h.Write(serverPub)
+	h.Write([]byte(label))
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+func (c *aesgcmConn) Read(p []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	if len(c.rbuf) == 0 {
+		var head [4]byte
+		if _, err := io.ReadFull(c.Conn, head[:]); err != nil {
+			return 0, err
+		}
+		l := binary.BigEndian.Uint32(head[:])
+		if l > maxRecordLen+uint32(c.reader.Overhead()) {
+			return 0, errors.New("cipher record too long")
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(c.Conn, data); err != nil {
+			return 0, err
+		}
+		data, err := c.reader.Open(data[:0], nonce(c.reader, c.rseq), data, nil)
+		if err != nil {
+			return 0, err
+		}
+		c.rseq++
+		c.rbuf = data
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *aesgcmConn) Write(p []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		l := len(p)
+		if l > maxRecordLen {
+			l = maxRecordLen
+		}
+		buf := make([]byte, 4, 4+l+c.writer.Overhead())
+		buf = c.writer.Seal(buf, nonce(c.writer, c.wseq), p[:l], nil)
+		c.wseq++
+		binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+		if _, err := c.Conn.Write(buf); err != nil {
+			return n, err
+		}
+		n += l
+		p = p[l:]
+	}
+	return n, nil
+}
+
+func init() {
+	RegisterCipher("aesgcm", func(config *ServiceConfig) (Cipher, error) {
+		return &AESGCM{Key: []byte(config.CipherKey), Timeout: config.HandshakeTimeout}, nil
+	})
+}
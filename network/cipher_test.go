@@ -0,0 +1,258 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cipherPipe 创建一对加密连接
+func cipherPipe(client, server *AESGCM) (net.Conn, net.Conn) {
+	c, s := net.Pipe()
+	return client.Client(c), server.Server(s)
+}
+
+func TestAESGCM(t *testing.T) {
+	cli, srv := cipherPipe(&AESGCM{Key: []byte("key")}, &AESGCM{Key: []byte("key")})
+	defer cli.Close()
+	defer srv.Close()
+
+	// 超过一条记录的最大长度，验证分多条记录发送
+	data := make([]byte, 3*maxRecordLen+100)
+	rand.Read(data)
+	go func() {
+		if _, err := cli.Write(data); err != nil {
+			t.Error(err)
+		}
+	}()
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(srv, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("client to server data mismatch")
+	}
+
+	go func() {
+		if _, err := srv.Write([]byte("pong")); err != nil {
+			t.Error(err)
+		}
+	}()
+	buf = make([]byte, 4)
+	if _, err := io.ReadFull(cli, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("server to client data mismatch: %q", buf)
+	}
+}
+
+func TestAESGCMWrongKey(t *testing.T) {
+	cli, srv := cipherPipe(&AESGCM{Key: []byte("key1")}, &AESGCM{Key: []byte("key2")})
+	defer cli.Close()
+	defer srv.Close()
+
+	go cli.Write([]byte("hello"))
+	if _, err := srv.Read(make([]byte, 5)); err == nil {
+		t.Fatal("read with wrong key should fail")
+	}
+}
+
+// tamperConn 握手后修改读取到的密文
+type tamperConn struct {
+	net.Conn
+	n int // 已读取的字节数
+}
+
+func (c *tamperConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := 0; i < n; i++ {
+		// 跳过32字节公钥和4字节记录长度，修改密文的第一个字节
+		if c.n+i == 32+4 {
+			p[i] ^= 0xFF
+		}
+	}
+	c.n += n
+	return n, err
+}
+
+func TestAESGCMTamper(t *testing.T) {
+	c, s := net.Pipe()
+	cfg := &AESGCM{}
+	cli, srv := cfg.Client(c), cfg.Server(&tamperConn{Conn: s})
+	defer cli.Close()
+	defer srv.Close()
+
+	go cli.Write([]byte("hello"))
+	if _, err := srv.Read(make([]byte, 5)); err == nil {
+		t.Fatal("read tampered record should fail")
+	}
+}
+
+func TestAESGCMDeadline(t *testing.T) {
+	cli, srv := cipherPipe(&AESGCM{Timeout: time.Minute}, &AESGCM{Timeout: time.Minute})
+	defer cli.Close()
+	defer srv.Close()
+
+	// 握手前设置的读取期限在握手结束后仍然有效
+	cli.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	go srv.Read(make([]byte, 1))
+	if _, err := cli.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := cli.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read deadline not restored: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("read deadline not restored")
+	}
+}
+
+// testCert 生成证书和秘钥文件，parent 为nil时生成自签名CA证书
+func testCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+// tlsHandshake 使用服务配置在管道上握手，返回客户端和服务端的握手错误
+func tlsHandshake(t *testing.T, client, server *ServiceConfig) (error, error) {
+	cc, err := client.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := server.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, s := net.Pipe()
+	cli, srv := tls.Client(c, cc), tls.Server(s, sc)
+	defer cli.Close()
+	defer srv.Close()
+
+	ch := make(chan error, 1)
+	go func() {
+		err := srv.Handshake()
+		s.Close()
+		ch <- err
+	}()
+	cerr := cli.Handshake()
+	if cerr == nil {
+		// TLS1.3 客户端先完成握手，读取一次等待服务端验证客户端证书
+		cli.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := cli.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) &&
+			!errors.Is(err, io.ErrClosedPipe) && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cerr = err
+		}
+	}
+	c.Close()
+	return cerr, <-ch
+}
+
+func TestTLS(t *testing.T) {
+	ca, caKey, caFile, _ := testCert(t, "ca", nil, nil)
+	_, _, srvCert, srvKey := testCert(t, "server", ca, caKey)
+	_, _, otherCA, _ := testCert(t, "other", nil, nil)
+
+	server := &ServiceConfig{CertFile: srvCert, KeyFile: srvKey}
+	client := &ServiceConfig{IsClient: true, CAFile: caFile, ServerName: "server"}
+	if !server.useTLS() || !client.useTLS() {
+		t.Fatal("tls not enabled")
+	}
+	if cerr, serr := tlsHandshake(t, client, server); cerr != nil || serr != nil {
+		t.Fatalf("tls handshake error: %v %v", cerr, serr)
+	}
+
+	// 客户端不信任服务端证书
+	untrusted := &ServiceConfig{IsClient: true, CAFile: otherCA, ServerName: "server"}
+	if cerr, _ := tlsHandshake(t, untrusted, server); cerr == nil {
+		t.Fatal("untrusted server certificate accepted")
+	}
+
+	// 服务端名称不匹配
+	wrongName := &ServiceConfig{IsClient: true, CAFile: caFile, ServerName: "other"}
+	if cerr, _ := tlsHandshake(t, wrongName, server); cerr == nil {
+		t.Fatal("wrong server name accepted")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, caKey, caFile, _ := testCert(t, "ca", nil, nil)
+	_, _, srvCert, srvKey := testCert(t, "server", ca, caKey)
+	_, _, cliCert, cliKey := testCert(t, "client", ca, caKey)
+	other, otherKey, _, _ := testCert(t, "other", nil, nil)
+	_, _, badCert, badKey := testCert(t, "client", other, otherKey)
+
+	if _, err := (&ServiceConfig{CertFile: srvCert, KeyFile: srvKey, ClientAuth: true}).tlsConfig(); err == nil {
+		t.Fatal("client auth without CAFile should fail")
+	}
+
+	server := &ServiceConfig{CertFile: srvCert, KeyFile: srvKey, CAFile: caFile, ClientAuth: true}
+	client := &ServiceConfig{IsClient: true, CertFile: cliCert, KeyFile: cliKey, CAFile: caFile, ServerName: "server"}
+	if cerr, serr := tlsHandshake(t, client, server); cerr != nil || serr != nil {
+		t.Fatalf("mtls handshake error: %v %v", cerr, serr)
+	}
+
+	// 客户端没有证书
+	noCert := &ServiceConfig{IsClient: true, CAFile: caFile, ServerName: "server"}
+	if _, serr := tlsHandshake(t, noCert, server); serr == nil {
+		t.Fatal("client without certificate accepted")
+	}
+
+	// 客户端证书不是由CA签发
+	wrongCA := &ServiceConfig{IsClient: true, CertFile: badCert, KeyFile: badKey, CAFile: caFile, ServerName: "server"}
+	if _, serr := tlsHandshake(t, wrongCA, server); serr == nil {
+		t.Fatal("client certificate signed by unknown CA accepted")
+	}
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// CloseReason 连接关闭原因，在 AfterClosed 中通过 Context.CloseReason 获取
+type CloseReason int32
+
+const (
+	CloseNone         CloseReason = iota // 连接没有关闭
+	CloseNormal                          // 调用 Session.Close 主动关闭
+	ClosePeer                            // 对端关闭连接
+	CloseReadError                       // 读取数据失败
+	CloseReadTimeout                     // 读取数据超时，见 ServiceConfig.ReadTimeout
+	CloseWriteError                      // 发送数据失败
+	CloseWriteTimeout                    // 发送数据超时，见 ServiceConfig.WriteTimeout
+	CloseChannelFull                     // 发送或接收队列已满，见 ServiceConfig.SendPolicy ServiceConfig.RecvPolicy
+	CloseRejected                        // AfterConnected 过滤器拒绝连接
+	CloseShutdown                        // 服务关闭
+	CloseIdle                            // 长时间没有收到消息，见 ServiceConfig.IdleTimeout
+	CloseProtocol                        // 收到的数据不符合协议
+	CloseRateLimited                     // 超过限流限制，见 RateLimit
+	CloseAuthTimeout                     // 没有在期限内通过认证，见 AuthConfig.Timeout
+	CloseAuthFailed                      // 认证失败
+	CloseKicked                          // 被踢下线，见 Session.Kick
+	CloseResumed                         // 新连接恢复了断开的连接，见 ResumeConfig
+	closeReasonMax
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNone:
+		return "none"
+	case CloseNormal:
+		return "normal"
+	case ClosePeer:
+		return "peer closed"
+	case CloseReadError:
+		return "read error"
+	case CloseReadTimeout:
+		return "read timeout"
+	case CloseWriteError:
+		return "write error"
+	case CloseWriteTimeout:
+		return "write timeout"
+	case CloseChannelFull:
+		return "channel full"
+	case CloseRejected:
+		return "rejected"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseIdle:
+		return "idle"
+	case CloseProtocol:
+		return "protocol error"
+	case CloseRateLimited:
+		return "rate limited"
+	case CloseAuthTimeout:
+		return "auth timeout"
+	case CloseAuthFailed:
+		return "auth failed"
+	case CloseKicked:
+		return "kicked"
+	case CloseResumed:
+		return "resumed"
+	}
+	return "unknown"
+}
+
+var closeCounts [closeReasonMax]int64
+
+// CloseCounts 各个原因关闭的连接数量，用于监控
+func CloseCounts() map[CloseReason]int64 {
+	m := make(map[CloseReason]int64, closeReasonMax)
+	for i := CloseNormal; i < closeReasonMax; i++ {
+		m[i] = atomic.LoadInt64(&closeCounts[i])
+	}
+	return m
+}
+
+// setCloseReason 记录关闭原因，只记录第一次
+func (s *Session) setCloseReason(reason CloseReason) {
+	if atomic.CompareAndSwapInt32((*int32)(&s.reason), int32(CloseNone), int32(reason)) {
+		if !parkable(reason) {
+			atomic.StoreInt32(&s.final, 1)
+		}
+	}
+}
+
+// countClose 统计关闭原因，连接最终关闭时调用，等待恢复的连接恢复后不统计
+func (s *Session) countClose() {
+	if reason := s.CloseReason(); reason > CloseNone && reason < closeReasonMax {
+		atomic.AddInt64(&closeCounts[reason], 1)
+	}
+}
+
+// CloseReason 连接关闭原因，连接没有关闭时为 CloseNone
+func (s *Session) CloseReason() CloseReason {
+	return CloseReason(atomic.LoadInt32((*int32)(&s.reason)))
+}
+
+// readCloseReason 读取数据失败的关闭原因
+func readCloseReason(err error) CloseReason {
+	var ne net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ClosePeer
+	case errors.As(err, &ne) && ne.Timeout():
+		return CloseReadTimeout
+	}
+	return CloseReadError
+}
+
+// writeCloseReason 发送数据失败的关闭原因
+func writeCloseReason(err error) CloseReason {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return CloseWriteTimeout
+	}
+	return CloseWriteError
+}
@@ -0,0 +1,112 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCloseReasonString(t *testing.T) {
+	seen := make(map[string]CloseReason)
+	for r := CloseNone; r < closeReasonMax; r++ {
+		s := r.String()
+		if s == "unknown" {
+			t.Fatalf("reason %d has no name", r)
+		}
+		if o, ok := seen[s]; ok {
+			t.Fatalf("reason %d and %d both named %q", o, r, s)
+		}
+		seen[s] = r
+	}
+	if s := closeReasonMax.String(); s != "unknown" {
+		t.Fatalf("reason %d named %q", closeReasonMax, s)
+	}
+}
+
+func TestIOCloseReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		read, write CloseReason
+	}{
+		{"eof", io.EOF, ClosePeer, CloseWriteError},
+		{"unexpected eof", io.ErrUnexpectedEOF, ClosePeer, CloseWriteError},
+		{"timeout", os.ErrDeadlineExceeded, CloseReadTimeout, CloseWriteTimeout},
+		{"other", errors.New("broken"), CloseReadError, CloseWriteError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readCloseReason(tt.err); got != tt.read {
+				t.Errorf("read: got %v, want %v", got, tt.read)
+			}
+			if got := writeCloseReason(tt.err); got != tt.write {
+				t.Errorf("write: got %v, want %v", got, tt.write)
+			}
+		})
+	}
+}
+
+func TestCloseReasonPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		reject bool // 服务端 AfterConnected 拒绝连接
+		close  func(p *pipeTest, ss, cs *Session)
+		server CloseReason // 服务端连接的关闭原因
+		client CloseReason // 客户端连接的关闭原因
+	}{
+		{"close", false, func(p *pipeTest, ss, cs *Session) { _ = cs.Close() }, ClosePeer, CloseNormal},
+		{"first reason", false, func(p *pipeTest, ss, cs *Session) {
+			_ = ss.CloseWithReason(CloseKicked)
+			_ = ss.Close()
+		}, CloseKicked, ClosePeer},
+		{"shutdown", false, func(p *pipeTest, ss, cs *Session) { p.server.Shutdown() }, CloseShutdown, ClosePeer},
+		{"idle", false, func(p *pipeTest, ss, cs *Session) { ss.SC.IdleTimeout = time.Millisecond }, CloseIdle, ClosePeer},
+		{"rejected", true, nil, CloseRejected, ClosePeer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testMsgHandler(t).SetHandlerFunc(1, new(pipeMsg), func(c *Context) {})
+			server, client := testConfigs(t)
+			p := newPipeTest(t, server, client)
+
+			// 关闭后记录双方连接的关闭原因
+			var sr, cr []CloseReason
+			testFilter(server, &FilterFunc{AfterClosed: func(c *Context) bool {
+				sr = append(sr, c.CloseReason())
+				return true
+			}})
+			testFilter(client, &FilterFunc{AfterClosed: func(c *Context) bool {
+				cr = append(cr, c.CloseReason())
+				return true
+			}})
+			if tt.reject {
+				testFilter(server, &FilterFunc{AfterConnected: func(c *Context) bool { return false }})
+			}
+			before := CloseCounts()
+			if !tt.reject {
+				ss, cs := p.connected()
+				testOnModule(func() { tt.close(p, ss, cs) })
+			}
+			p.wait("closed", func() bool { return len(sr) > 0 && len(cr) > 0 })
+
+			if sr[0] != tt.server || cr[0] != tt.client {
+				t.Fatalf("server %v client %v, want %v %v", sr[0], cr[0], tt.server, tt.client)
+			}
+			// 每个关闭的连接统计一次
+			after := CloseCounts()
+			for r := CloseNormal; r < closeReasonMax; r++ {
+				var want int64
+				for _, v := range []CloseReason{tt.server, tt.client} {
+					if v == r {
+						want++
+					}
+				}
+				if got := after[r] - before[r]; got != want {
+					t.Errorf("%v counted %d, want %d", r, got, want)
+				}
+			}
+		})
+	}
+}
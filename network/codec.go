@@ -0,0 +1,159 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/skeletongo/cube/encoding"
+)
+
+// Codec 网络通信封包解包规则
+// 负责消息头的序列化及数据包的读写，消息数据的序列化由 encoding 包负责
+// 每个服务可以使用不同的封包解包规则，见 ServiceConfig.Codec
+// 消息头中的分片信息需要完整保留，否则不能发送超过 ServiceConfig.FragmentSize 的消息
+// 同一个服务的所有连接共用一个实例，需要并发安全
+type Codec interface {
+	// Marshal 封包
+	// head 消息头
+	// et 消息数据的编码类型
+	// data 序列化后的消息数据
+	// 返回数据包，通过 Write 发送
+	Marshal(head *MsgHead, et encoding.EncodeType, data []byte) ([]byte, error)
+
+	// Unmarshal 解包
+	// pkg 通过 Read 读取的数据包
+	// 返回消息头，消息数据的编码类型，序列化后的消息数据
+	Unmarshal(pkg []byte) (head *MsgHead, et encoding.EncodeType, data []byte, err error)
+
+	// Read 读取一个数据包
+	Read(r io.Reader) ([]byte, error)
+
+	// Write 发送一个数据包
+	Write(w io.Writer, pkg []byte) error
+}
+
+var codecCreators = make(map[string]func(config *ServiceConfig) Codec)
+
+// RegisterCodec 注册封包解包规则
+// name 名称，对应配置中的 ServiceConfig.Codec
+// f 创建方法，每个服务调用一次
+func RegisterCodec(name string, f func(config *ServiceConfig) Codec) {
+	codecCreators[name] = f
+}
+
+func newCodec(config *ServiceConfig) (Codec, error) {
+	name := config.Codec
+	if name == "" {
+		name = "default"
+	}
+	f, ok := codecCreators[name]
+	if !ok {
+		return nil, fmt.Errorf("codec not found: %s", name)
+	}
+	return f(config), nil
+}
+
+// DefaultCodec 默认的封包解包规则
+//
+// 数据包结构
+// ---------------------------------------------
+// | len | EncodeType | MsgID | [Seq] | [Client] | [Fragment] | Data |
+// ---------------------------------------------
+// len 见 PkgParser
+// EncodeType MsgID Seq Client Fragment Data 见 MsgParser，消息号占用的字节数见 ServiceConfig.MsgIDLen
+// 消息数据的压缩见 ServiceConfig.Compress
+type DefaultCodec struct {
+	Msg *MsgParser
+	Pkg *PkgParser
+}
+
+func NewDefaultCodec(msg *MsgParser, pkg *PkgParser) *DefaultCodec {
+	return &DefaultCodec{
+		Msg: msg,
+		Pkg: pkg,
+	}
+}
+
+func (d *DefaultCodec) Marshal(head *MsgHead, et encoding.EncodeType, data []byte) ([]byte, error) {
+	pkg, err := d.Msg.marshal(head, et, data, int(d.Pkg.lenMsgLen))
+	if err != nil {
+		return nil, err
+	}
+	return d.Pkg.Encode(pkg)
+}
+
+func (d *DefaultCodec) Unmarshal(pkg []byte) (head *MsgHead, et encoding.EncodeType, data []byte, err error) {
+	n := int(d.Pkg.lenMsgLen)
+	if len(pkg) < n {
+		return nil, 0, nil, errors.New("lenMsgLen too short")
+	}
+	head, et, err = d.Msg.unmarshal(pkg[n:])
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	data, err = d.Msg.body(head, pkg[n+d.Msg.HeadLen(head):])
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return head, et, data, nil
+}
+
+func (d *DefaultCodec) Read(r io.Reader) ([]byte, error) {
+	return d.Pkg.DecodeByReader(r)
+}
+
+func (d *DefaultCodec) Write(w io.Writer, pkg []byte) error {
+	_, err := w.Write(pkg)
+	return err
+}
+
+// sameFormat 两个编解码器的数据包格式相同，收到的数据包修改消息头后可以直接转发
+func (d *DefaultCodec) sameFormat(o *DefaultCodec) bool {
+	return d.Msg.msgIDLen == o.Msg.msgIDLen && d.Msg.endian == o.Msg.endian &&
+		d.Pkg.lenMsgLen == o.Pkg.lenMsgLen && d.Pkg.endian == o.Pkg.endian
+}
+
+// msgPacket 去掉长度字段后的数据包，见 Context.Packet，自定义 Codec 时为完整的数据包
+func msgPacket(c Codec, pkg []byte) []byte {
+	if d, ok := c.(*DefaultCodec); ok && len(pkg) >= int(d.Pkg.lenMsgLen) {
+		return pkg[d.Pkg.lenMsgLen:]
+	}
+	return pkg
+}
+
+// encodeMsg 消息数据序列化
+func encodeMsg(msg interface{}) (encoding.EncodeType, []byte, error) {
+	et := encoding.TypeTest(msg)     // 数据类型
+	p, _ := encoding.GetEncoding(et) // 获取编码器
+	data, err := p.Marshal(msg)
+	return et, data, err
+}
+
+// unmarshalMsg 消息反序列化
+// msgID 消息号，编码类型不支持时作为错误数据返回
+// et 消息数据的编码类型
+// data 序列化后的消息数据
+// msg 消息结构体指针
+func unmarshalMsg(msgID uint32, et encoding.EncodeType, data []byte, msg interface{}) error {
+	p, has := encoding.GetEncoding(et)
+	if !has {
+		return NewError(errors.New("encoder error"), ErrorTypeEncoder, msgID)
+	}
+	return p.Unmarshal(data, msg)
+}
+
+func init() {
+	RegisterCodec("default", func(config *ServiceConfig) Codec {
+		msg := gMsgParser
+		if (config.MsgIDLen != 0 && config.MsgIDLen != gMsgParser.msgIDLen) ||
+			config.Compress != "" || config.MaxDecompressLen > 0 {
+			msg = gMsgParser.clone()
+			msg.SetMsgIDLen(config.MsgIDLen)
+			// 压缩算法名称已经在 ServiceConfig.init 中校验过
+			_ = msg.SetCompress(config.Compress, config.CompressThreshold)
+			msg.SetMaxDecompressLen(config.MaxDecompressLen)
+		}
+		return NewDefaultCodec(msg, gPkgParser)
+	})
+}
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 内置的压缩算法编号
+const (
+	CompressGzip  uint8 = iota + 1 // compress/gzip
+	CompressFlate                  // compress/flate
+)
+
+// Compressor 压缩算法，需要并发安全
+type Compressor interface {
+	// Compress 压缩
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress 解压
+	// max 解压后的最大字节数，超过时返回错误
+	Decompress(data []byte, max int) ([]byte, error)
+}
+
+type compressorInfo struct {
+	id   uint8
+	name string
+	c    Compressor
+}
+
+var (
+	compressors      = make(map[uint8]*compressorInfo)
+	compressorByName = make(map[string]*compressorInfo)
+)
+
+// RegisterCompressor 注册压缩算法
+// id 压缩算法编号，写入压缩后的消息数据的第一个字节，用来解压，不能为0
+// name 名称，对应配置中的 ServiceConfig.Compress
+// c 压缩算法
+func RegisterCompressor(id uint8, name string, c Compressor) {
+	if id == 0 {
+		panic("compressor id can not be 0")
+	}
+	info := &compressorInfo{id: id, name: name, c: c}
+	compressors[id] = info
+	compressorByName[name] = info
+}
+
+func getCompressor(name string) (*compressorInfo, error) {
+	info, ok := compressorByName[name]
+	if !ok {
+		return nil, fmt.Errorf("compressor not found: %s", name)
+	}
+	return info, nil
+}
+
+// readAll 读取解压后的数据，超过最大字节数时返回错误
+func readAll(r io.Reader, max int) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	n, err := io.Copy(buf, io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(max) {
+		return nil, errors.New("decompressed message too long")
+	}
+	return buf.Bytes(), nil
+}
+
+// Gzip compress/gzip 压缩
+type Gzip struct {
+	Level int
+}
+
+func (g *Gzip) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := gzip.NewWriterLevel(buf, g.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *Gzip) Decompress(data []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAll(r, max)
+}
+
+// Flate compress/flate 压缩
+type Flate struct {
+	Level int
+}
+
+func (f *Flate) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := flate.NewWriter(buf, f.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *Flate) Decompress(data []byte, max int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readAll(r, max)
+}
+
+func init() {
+	RegisterCompressor(CompressGzip, "gzip", &Gzip{Level: gzip.DefaultCompression})
+	RegisterCompressor(CompressFlate, "flate", &Flate{Level: flate.DefaultCompression})
+}
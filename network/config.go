@@ -39,37 +39,81 @@ func (s ServerKey) Parse() (areaId, typeId uint8, id uint16) {
 // ServiceConfig 服务配置
 type ServiceConfig struct {
 	ServerInfo
-	CertFile   string // 证书文件地址
-	KeyFile    string // 秘钥文件地址
-	Path       string // websocket连接名称
-	Protocol   string // 支持的协议 "tcp" "ws" "wss"
-	Ip         string // 内网ip地址
-	OutIp      string // 公网ip地址
-	Port       int    // 端口
-	MaxRecv    int    // 接收队列缓存大小
-	MaxSend    int    // 发送队列缓存大小
-	MaxConnNum int    // 支持的最大连接数量（IsClient为false时有效）
+	CertFile           string // 证书文件地址
+	KeyFile            string // 秘钥文件地址
+	CAFile             string // CA证书文件地址，服务端用来验证客户端证书，客户端用来验证服务端证书
+	ClientAuth         bool   // 服务端是否要求并验证客户端证书（双向认证），需要配置 CAFile
+	TLS                bool   // 客户端是否使用TLS连接，配置了 CertFile 或 CAFile 时自动启用
+	ServerName         string // 客户端验证的服务端证书名称，默认为 Ip
+	InsecureSkipVerify bool   // 客户端是否跳过服务端证书验证，仅用于测试
+	Cipher             string // tcp连接的加密传输层名称 "aesgcm"，为空时不加密，自定义加密通过 RegisterCipher 注册
+	CipherKey          string // 加密传输层的预共享秘钥
+	Path               string // websocket连接名称，unix协议的套接字文件路径，pipe协议的管道名称
+	Protocol           string // 支持的协议 "tcp" "ws" "wss" "kcp" "unix" "pipe"
+	Codec              string // 封包解包规则名称，默认 "default"，自定义规则通过 RegisterCodec 注册
+	Handler            string // 消息注册表名称，为空时使用默认注册表，见 GetMsgHandler
+	MsgIDLen           int    // 默认封包解包规则中消息号占用的字节数，2或4，为0时使用全局配置 Configuration.MsgIDLen
+	Compress           string // 默认封包解包规则中消息数据的压缩算法 "gzip" "flate"，为空时不压缩，自定义算法通过 RegisterCompressor 注册
+	CompressThreshold  int    // 消息数据超过这个字节数时压缩，默认1024
+	MaxDecompressLen   int    // 解压后消息数据最大字节数，默认4M
+	FragmentSize       int    // 消息数据超过这个字节数时分片发送，默认为 Configuration.MaxMsgLen 减去消息头预留的32字节，小于0时不分片
+	MaxReassembleLen   int    // 每个连接分片重组缓存最大字节数，超过时关闭连接，默认为 Configuration.MaxMsgLen 的64倍
+	Ip                 string // 内网ip地址
+	OutIp              string // 公网ip地址
+	Port               int    // 端口
+	MaxRecv            int    // 接收队列缓存大小
+	MaxSend            int    // 发送队列缓存大小
+	SendPolicy         string // 发送队列已满时的处理策略 "close" "block" "drop_oldest" "drop_newest" "spill"，默认 "close"
+	RecvPolicy         string // 接收队列已满时的处理策略，同 SendPolicy，默认 "block"
+	HighWater          int    // 策略为 "spill" 时溢出队列长度超过这个值时通过 OnHighWater 通知，默认等于队列缓存大小
+	MaxConnNum         int    // 支持的最大连接数量（IsClient为false时有效）
+
+	DrainTimeout time.Duration // 服务关闭时等待连接断开的时间，单位秒，为0时立即关闭连接（IsClient为false时有效）
+	GoAwayMsgID  uint32        // 服务关闭时通知连接的消息号，消息结构为 GoAway，为0时不通知
+	ReusePort    bool          // 是否开启 SO_REUSEPORT，多个进程可以同时监听同一个端口
 
 	IsClient          bool          // 连接发起方
 	AutoReconnect     bool          // 是否自动断线重连
-	ReconnectInterval time.Duration // 重试拨号时间间隔
+	ReconnectInterval time.Duration // 第一次重试拨号的时间间隔，之后按 Restart 策略递增
+	Restart           RestartPolicy // 服务重启策略
 	ClientNum         int           // 建立连接数量（IsClient为true时有效）
 
-	MTU             int           // 网络传输最大数据包,单位字节
-	Linger          int           // 控制连接断开时的行为，连接断开后是否立刻丢弃还没有发送的缓存数据，单位秒
-	KeepAlive       bool          // 是否启用tcp心跳功能
-	KeepAlivePeriod time.Duration // 开启心跳功能后的发送消息的时间间隔,单位秒
-	ReadBufferSize  int           // 接收数据缓冲区大小,单位字节
-	WriteBufferSize int           // 发送数据缓冲区大小,单位字节
-	ReadTimeout     time.Duration // 读取数据超时时长,单位秒
-	WriteTimeout    time.Duration // 写入数据超时时长,单位秒
-	HTTPTimeout     time.Duration // websocket 建立连接的超时时间,单位秒
+	MTU              int           // 网络传输最大数据包,单位字节，kcp协议默认1400
+	KCPWindow        int           // kcp协议收发窗口大小，默认128
+	KCPInterval      int           // kcp协议状态更新间隔，单位毫秒，默认10
+	Linger           int           // 控制连接断开时的行为，连接断开后是否立刻丢弃还没有发送的缓存数据，单位秒
+	KeepAlive        bool          // 是否启用tcp心跳功能
+	KeepAlivePeriod  time.Duration // 开启心跳功能后的发送消息的时间间隔,单位秒
+	ReadBufferSize   int           // 接收数据缓冲区大小,单位字节
+	WriteBufferSize  int           // 发送数据缓冲区大小,单位字节
+	ReadTimeout      time.Duration // 读取数据超时时长,单位秒
+	WriteTimeout     time.Duration // 写入数据超时时长,单位秒
+	PingMsgID        uint32        // 心跳消息号，消息结构为 Ping，客户端定时发送，服务端自动返回，为0时不发送心跳
+	PingInterval     time.Duration // 客户端发送心跳的时间间隔,单位秒，默认10秒
+	IdleTimeout      time.Duration // 连接多久没有收到消息后关闭,单位秒，为0时不关闭
+	SendTimeout      time.Duration // 发送策略为 "block" 时等待队列有空位的最长时间,单位毫秒，默认100毫秒，会阻塞module节点
+	RecvTimeout      time.Duration // 接收策略为 "block" 时等待队列有空位的最长时间,单位毫秒，为0时一直等待
+	HTTPTimeout      time.Duration // websocket 建立连接的超时时间,单位秒
+	HandshakeTimeout time.Duration // tls、加密传输层及kcp握手的超时时间,单位秒，默认10秒
 
 	FilterChain []string     // 过滤器列表，要启用的过滤器名称及调用顺序
 	filterChain *FilterChain `json:"-"`
 	MiddleChain []string     // 中间件列表，要启用的中间件名称及调用顺序
 	middleChain *MiddleChain `json:"-"`
 
+	RateLimit      RateLimit    // 限流过滤器配置，FilterChain 中有 "ratelimit" 时生效
+	Auth           AuthConfig   // 认证过滤器配置，FilterChain 中有 "auth" 时生效
+	DuplicateLogin string       // 同一个用户重复登录时的处理策略 "kick" 踢掉旧连接 "reject" 拒绝新连接，默认 "kick"，见 BindUser
+	KickMsgID      uint32       // 踢下线通知的消息号，消息结构为 Kick，为0时不通知
+	Resume         ResumeConfig // 连接恢复配置
+
+	Forward      []*ForwardRule // 网关转发规则，收到未注册的消息时按规则转发给后端服务（IsClient为false时有效）
+	TrustForward bool           // 服务端是否信任连接发来的网关转发消息，只在网关连接的后端服务上开启，见 Session.trusted
+
+	codec   Codec
+	handler *MsgHandler
+	cipher  Cipher
+
 	seq uint32
 }
 
@@ -85,6 +129,23 @@ func (sc *ServiceConfig) init() (err error) {
 	if sc.MaxSend <= 0 {
 		sc.MaxSend = 1000
 	}
+	if sc.SendPolicy == "" {
+		sc.SendPolicy = PolicyClose
+	}
+	if sc.RecvPolicy == "" {
+		sc.RecvPolicy = PolicyBlock
+	}
+	if sc.HighWater <= 0 {
+		sc.HighWater = sc.MaxSend
+	}
+	if sc.SendTimeout > 0 {
+		sc.SendTimeout *= time.Millisecond
+	} else {
+		sc.SendTimeout = DefaultSendTimeout
+	}
+	if sc.RecvTimeout > 0 {
+		sc.RecvTimeout *= time.Millisecond
+	}
 	if sc.MaxConnNum <= 0 {
 		sc.MaxConnNum = 5000
 	}
@@ -93,6 +154,7 @@ func (sc *ServiceConfig) init() (err error) {
 	} else {
 		sc.ReconnectInterval *= time.Second
 	}
+	sc.Restart.init()
 	if sc.ClientNum < 0 {
 		sc.ClientNum = 0
 	}
@@ -105,11 +167,87 @@ func (sc *ServiceConfig) init() (err error) {
 	if sc.WriteTimeout > 0 {
 		sc.WriteTimeout *= time.Second
 	}
+	if sc.DrainTimeout > 0 {
+		sc.DrainTimeout *= time.Second
+	}
+	if sc.PingInterval > 0 {
+		sc.PingInterval *= time.Second
+	} else {
+		sc.PingInterval = DefaultPingInterval
+	}
+	if sc.IdleTimeout > 0 {
+		sc.IdleTimeout *= time.Second
+	}
 	if sc.HTTPTimeout <= 10 {
 		sc.HTTPTimeout = 10 * time.Second
 	} else {
 		sc.HTTPTimeout *= time.Second
 	}
+	if sc.HandshakeTimeout > 0 {
+		sc.HandshakeTimeout *= time.Second
+	} else {
+		sc.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	if sc.FragmentSize == 0 {
+		sc.FragmentSize = int(gPkgParser.maxMsgLen) - fragmentHeadRoom
+	}
+	if sc.MaxReassembleLen <= 0 {
+		sc.MaxReassembleLen = DefaultReassembleN * int(gPkgParser.maxMsgLen)
+	}
+	sc.handler = GetMsgHandler(sc.Handler)
+	if err = checkPolicy(sc.SendPolicy); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" SendPolicy error: %v", err)
+		return err
+	}
+	if sc.SendPolicy == PolicyDropOldest && sc.FragmentSize > 0 {
+		// 发送协程可能已经取出分片消息的前几片，丢弃剩余的分片会导致对端重组失败
+		err = fmt.Errorf("%s requires FragmentSize < 0", PolicyDropOldest)
+		logrus.WithField("ServiceInfo", sc).Errorf(" SendPolicy error: %v", err)
+		return err
+	}
+	if err = checkPolicy(sc.RecvPolicy); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" RecvPolicy error: %v", err)
+		return err
+	}
+	if err = sc.RateLimit.init(); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" RateLimit error: %v", err)
+		return err
+	}
+	if sc.DuplicateLogin == "" {
+		sc.DuplicateLogin = DuplicateKick
+	}
+	if err = checkDuplicateLogin(sc.DuplicateLogin); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" DuplicateLogin error: %v", err)
+		return err
+	}
+	if err = sc.Resume.init(sc); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" Resume error: %v", err)
+		return err
+	}
+	if err = sc.Auth.init(sc); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" Auth error: %v", err)
+		return err
+	}
+	if sc.Compress != "" {
+		if _, err = getCompressor(sc.Compress); err != nil {
+			logrus.WithField("ServiceInfo", sc).Errorf(" Compress error: %v", err)
+			return err
+		}
+	}
+	if sc.codec, err = newCodec(sc); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" Codec error: %v", err)
+		return err
+	}
+	if sc.cipher, err = newCipher(sc); err != nil {
+		logrus.WithField("ServiceInfo", sc).Errorf(" Cipher error: %v", err)
+		return err
+	}
+	for _, v := range sc.Forward {
+		if err = v.init(); err != nil {
+			logrus.WithField("ServiceInfo", sc).Errorf(" Forward error: %v", err)
+			return err
+		}
+	}
 
 	if sc.filterChain, err = gFilterMgr.FilterChain(sc.FilterChain...); err != nil {
 		logrus.WithField("ServiceInfo", sc).Errorf(" FilterChain error: %v", err)
@@ -1,8 +1,11 @@
 package network
 
 import (
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/skeletongo/cube/encoding"
 )
 
 // Context 消息上下文
@@ -10,16 +13,39 @@ type Context struct {
 	*Session
 
 	// MsgID 消息号
-	MsgID uint16
+	MsgID uint32
 
 	// Msg 消息数据
 	Msg interface{}
 
-	// Packet 收到的未注册的消息号所在的数据包
+	// Seq 收到的rpc请求或返回的序号，发送消息时不会修改
+	Seq uint32
+
+	// Client 收到网关转发的消息时，消息所属客户端在网关上的连接标识
+	Client SessionKey
+
+	// UID 消息所属的用户ID，网关转发的消息为客户端在网关上绑定的用户ID，否则为当前连接绑定的用户ID，见 BindUser
+	UID uint64
+
+	// Packet 收到的未注册的消息号所在的数据包，不包括长度字段，使用默认 Codec 时可以通过 UnmarshalUnregister 解析
+	// 推荐通过 UnmarshalPacket 解析，分片消息没有完整的数据包，为nil
 	Packet []byte
+	et     encoding.EncodeType
+	data   []byte // 未注册的消息数据
 
-	// Keys 数据存储
+	// Keys 数据存储，类型不匹配时 GetString 等方法返回零值，新代码使用类型安全的 SessionValue
 	Keys sync.Map
+
+	values []interface{} // SessionValue 数据，下标为 SessionValue.index
+}
+
+// UnmarshalPacket 解析未注册的消息，只能在 ErrorMsgID 中调用
+// msg 消息结构体指针
+func (c *Context) UnmarshalPacket(msg interface{}) error {
+	if c.data == nil {
+		return errors.New("no unregistered message")
+	}
+	return unmarshalMsg(c.MsgID, c.et, c.data, msg)
 }
 
 func (c *Context) Set(key string, value interface{}) {
@@ -0,0 +1,322 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Endpoint 服务节点，服务发现中注册的服务信息
+type Endpoint struct {
+	ServerInfo
+	Protocol string // 协议
+	Ip       string // 内网ip地址
+	OutIp    string // 公网ip地址
+	Port     int    // 端口
+	Path     string // websocket连接名称
+}
+
+func (e *Endpoint) String() string {
+	return fmt.Sprintf("%v, Protocol:%v, IP:%v, OutIP:%v, Port:%v",
+		e.ServerInfo.String(), e.Protocol, e.Ip, e.OutIp, e.Port)
+}
+
+// Addr 连接地址，优先使用公网ip地址
+func (e *Endpoint) Addr() string {
+	if e.OutIp != "" {
+		return e.OutIp
+	}
+	return e.Ip
+}
+
+func newEndpoint(config *ServiceConfig) *Endpoint {
+	return &Endpoint{
+		ServerInfo: config.ServerInfo,
+		Protocol:   config.Protocol,
+		Ip:         config.Ip,
+		OutIp:      config.OutIp,
+		Port:       config.Port,
+		Path:       config.Path,
+	}
+}
+
+// Registry 服务注册中心
+type Registry interface {
+	// Register 注册服务节点，重复注册用来刷新服务节点的存活时间
+	Register(e *Endpoint) error
+
+	// Deregister 注销服务节点
+	Deregister(e *Endpoint) error
+
+	// Endpoints 获取所有存活的服务节点
+	Endpoints() ([]*Endpoint, error)
+
+	// Close 关闭注册中心
+	Close() error
+}
+
+var registryCreators = make(map[string]func(config *DiscoveryConfig) (Registry, error))
+
+// RegisterRegistry 注册服务注册中心
+// name 名称，对应配置中的 Discovery.Registry
+// f 服务注册中心创建方法
+func RegisterRegistry(name string, f func(config *DiscoveryConfig) (Registry, error)) {
+	registryCreators[name] = f
+}
+
+// DiscoveryConfig 服务发现配置
+type DiscoveryConfig struct {
+	Registry string           // 服务注册中心名称，内置 "memory" "file"，为空时不启用服务发现
+	Path     string           // "file" 服务注册中心的共享目录
+	Interval time.Duration    // 刷新注册信息及查找服务节点的时间间隔,单位秒
+	TTL      time.Duration    // 服务节点超过此时长没有刷新视为下线,单位秒
+	Watch    []*ServiceConfig // 需要自动连接的服务，根据Area和Type查找服务节点，其它配置作为客户端配置模板
+}
+
+func (dc *DiscoveryConfig) init() error {
+	if dc.Interval <= 0 {
+		dc.Interval = 3 * time.Second
+	} else {
+		dc.Interval *= time.Second
+	}
+	if dc.TTL <= 0 {
+		dc.TTL = 3 * dc.Interval
+	} else {
+		dc.TTL *= time.Second
+	}
+	for _, v := range dc.Watch {
+		v.IsClient = true
+		if v.ClientNum <= 0 {
+			v.ClientNum = 1
+		}
+		if err := v.init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discovery 服务发现
+// 注册本地启动的服务，查找需要连接的服务节点，自动建立或关闭连接
+// 服务注册中心的读写都在单独的协程中执行，不阻塞module节点
+type Discovery struct {
+	network   *Network
+	config    *DiscoveryConfig
+	registry  Registry
+	mu        sync.Mutex
+	local     map[ServerKey]*Endpoint // 本地注册的服务节点
+	removed   []*Endpoint             // 等待注销的本地服务节点
+	released  []ServerKey             // 已经关闭的自动连接的服务，服务节点仍然存活时重新连接
+	peers     map[ServerKey]*Endpoint // 已经建立连接的服务节点，只在服务发现协程中访问
+	started   bool
+	notify    chan struct{} // 本地服务变化，通知服务发现协程立即刷新
+	closeSign chan struct{}
+	done      chan struct{} // 服务发现协程退出，本地服务已经全部注销
+}
+
+func NewDiscovery(n *Network, config *DiscoveryConfig) (*Discovery, error) {
+	f, ok := registryCreators[config.Registry]
+	if !ok {
+		return nil, fmt.Errorf("registry not found: %s", config.Registry)
+	}
+	r, err := f(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Discovery{
+		network:   n,
+		config:    config,
+		registry:  r,
+		local:     make(map[ServerKey]*Endpoint),
+		peers:     make(map[ServerKey]*Endpoint),
+		notify:    make(chan struct{}, 1),
+		closeSign: make(chan struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// register 注册本地服务，在服务发现协程中写入服务注册中心
+func (d *Discovery) register(config *ServiceConfig) {
+	d.mu.Lock()
+	d.local[config.Key()] = newEndpoint(config)
+	d.mu.Unlock()
+	d.wakeup()
+}
+
+// deregister 注销本地服务，在服务发现协程中从服务注册中心删除
+func (d *Discovery) deregister(config *ServiceConfig) {
+	d.mu.Lock()
+	e, ok := d.local[config.Key()]
+	if ok {
+		delete(d.local, config.Key())
+		d.removed = append(d.removed, e)
+	}
+	d.mu.Unlock()
+	if ok {
+		d.wakeup()
+	}
+}
+
+// release 自动连接的服务已经关闭，例如重连失败次数超过 RestartPolicy.MaxRetries，
+// 下次刷新时服务节点仍然存活则重新连接
+func (d *Discovery) release(config *ServiceConfig) {
+	d.mu.Lock()
+	d.released = append(d.released, config.Key())
+	d.mu.Unlock()
+}
+
+func (d *Discovery) wakeup() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Start 启动服务发现
+func (d *Discovery) Start() {
+	log.Tracef("discovery start, registry %s", d.config.Registry)
+	d.started = true
+	go func() {
+		t := time.NewTicker(d.config.Interval)
+		defer t.Stop()
+		defer close(d.done)
+
+		d.refresh()
+		for {
+			select {
+			case <-d.closeSign:
+				d.shutdown()
+				return
+			case <-d.notify:
+				d.refresh()
+			case <-t.C:
+				d.refresh()
+			}
+		}
+	}()
+}
+
+// flush 注销已经关闭的本地服务，返回需要刷新注册信息的本地服务
+func (d *Discovery) flush() []*Endpoint {
+	d.mu.Lock()
+	removed := d.removed
+	d.removed = nil
+	local := make([]*Endpoint, 0, len(d.local))
+	for _, v := range d.local {
+		local = append(local, v)
+	}
+	d.mu.Unlock()
+	for _, v := range removed {
+		if err := d.registry.Deregister(v); err != nil {
+			log.WithField("Endpoint", v).Errorf("discovery deregister error: %v", err)
+		}
+	}
+	return local
+}
+
+// refresh 刷新本地服务的注册信息，连接新上线的服务节点，断开已下线的服务节点
+func (d *Discovery) refresh() {
+	for _, v := range d.flush() {
+		if err := d.registry.Register(v); err != nil {
+			log.WithField("Endpoint", v).Errorf("discovery register error: %v", err)
+		}
+	}
+
+	d.mu.Lock()
+	for _, key := range d.released {
+		delete(d.peers, key)
+	}
+	d.released = nil
+	d.mu.Unlock()
+
+	endpoints, err := d.registry.Endpoints()
+	if err != nil {
+		log.Errorf("discovery get endpoints error: %v", err)
+		return
+	}
+
+	alive := make(map[ServerKey]struct{}, len(endpoints))
+	for _, e := range endpoints {
+		config := d.watch(e)
+		if config == nil {
+			continue
+		}
+		key := e.Key()
+		alive[key] = struct{}{}
+		if _, ok := d.peers[key]; ok {
+			continue
+		}
+		log.WithField("Endpoint", e).Info("discovery: endpoint online")
+		d.peers[key] = e
+		d.network.NewService(config)
+	}
+
+	for key, e := range d.peers {
+		if _, ok := alive[key]; ok {
+			continue
+		}
+		log.WithField("Endpoint", e).Info("discovery: endpoint offline")
+		delete(d.peers, key)
+		d.network.RemoveService(key)
+	}
+}
+
+// watch 根据服务节点创建客户端配置，不需要连接时返回nil
+func (d *Discovery) watch(e *Endpoint) *ServiceConfig {
+	d.mu.Lock()
+	_, ok := d.local[e.Key()]
+	d.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	for _, v := range d.config.Watch {
+		if v.Area != e.Area || v.Type != e.Type {
+			continue
+		}
+		config := *v
+		config.ServerInfo = e.ServerInfo
+		config.Ip = e.Addr()
+		config.Port = e.Port
+		config.Path = e.Path
+		if config.Protocol == "" {
+			config.Protocol = e.Protocol
+		}
+		return &config
+	}
+	return nil
+}
+
+// Close 关闭服务发现，在服务发现协程中注销所有本地服务
+func (d *Discovery) Close() {
+	select {
+	case <-d.closeSign:
+		return
+	default:
+		close(d.closeSign)
+	}
+	if !d.started {
+		go func() {
+			d.shutdown()
+			close(d.done)
+		}()
+	}
+}
+
+// shutdown 注销所有本地服务，关闭服务注册中心
+func (d *Discovery) shutdown() {
+	d.mu.Lock()
+	for key, e := range d.local {
+		d.removed = append(d.removed, e)
+		delete(d.local, key)
+	}
+	d.mu.Unlock()
+	d.flush()
+
+	if err := d.registry.Close(); err != nil {
+		log.Errorf("discovery registry close error: %v", err)
+	}
+	log.Trace("discovery close")
+}
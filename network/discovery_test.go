@@ -0,0 +1,143 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDiscovery 启动使用共享目录服务发现的网络模块，本地服务为 info，按 watch 自动连接服务
+func testDiscovery(t *testing.T, dir string, info ServerInfo, watch *ServiceConfig) (*Network, *ServiceConfig) {
+	dc := &DiscoveryConfig{
+		Registry: "file",
+		Path:     dir,
+		Watch:    []*ServiceConfig{watch},
+	}
+	if err := dc.init(); err != nil {
+		t.Fatal(err)
+	}
+	dc.Interval = 10 * time.Millisecond
+	dc.TTL = time.Second
+	dc.Watch[0].ReconnectInterval = time.Millisecond
+
+	server := &ServiceConfig{
+		ServerInfo: info,
+		Protocol:   "pipe",
+		Path:       fmt.Sprintf("%s/%d", t.Name(), info.Type),
+	}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNetwork()
+	var err error
+	if n.discovery, err = NewDiscovery(n, dc); err != nil {
+		t.Fatal(err)
+	}
+	if n.newService(server) == nil {
+		t.Fatal("server start error")
+	}
+	n.discovery.Start()
+	t.Cleanup(func() {
+		n.discovery.Close()
+		<-n.discovery.done
+		for k, s := range n.service {
+			if _, ok := n.removed[k]; !ok {
+				s.Shutdown()
+			}
+		}
+	})
+	return n, server
+}
+
+func TestDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	n1, s1 := testDiscovery(t, dir, ServerInfo{Area: 1, Type: 1, ID: 1},
+		&ServiceConfig{ServerInfo: ServerInfo{Area: 1, Type: 2}, AutoReconnect: true})
+	n2, s2 := testDiscovery(t, dir, ServerInfo{Area: 1, Type: 2, ID: 1},
+		&ServiceConfig{ServerInfo: ServerInfo{Area: 1, Type: 1}, AutoReconnect: true})
+
+	wait := func(what string, f func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !f() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s", what)
+			}
+			n1.Update()
+			n2.Update()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// connected 网络模块 n 已经通过服务发现连接到服务 key
+	connected := func(n *Network, key ServerKey) bool {
+		c, ok := n.service[key].(*TCPClient)
+		return ok && len(c.sessions) > 0
+	}
+	removed := func(n *Network, key ServerKey) bool {
+		_, ok := n.service[key]
+		return !ok && len(n.removed) == 0
+	}
+
+	// 两个网络模块互相发现并连接
+	wait("connected", func() bool { return connected(n1, s2.Key()) && connected(n2, s1.Key()) })
+
+	// 服务关闭后注销，对端移除连接这个服务的客户端
+	n2.RemoveService(s2.Key())
+	wait("n1 removed", func() bool { return removed(n2, s2.Key()) && removed(n1, s2.Key()) })
+	if _, err := os.Stat(filepath.Join(dir, "1_2_1.json")); !os.IsNotExist(err) {
+		t.Fatalf("endpoint not deregistered: %v", err)
+	}
+	if !connected(n2, s1.Key()) {
+		t.Fatal("n2 disconnected")
+	}
+
+	n1.RemoveService(s1.Key())
+	wait("n2 removed", func() bool { return removed(n1, s1.Key()) && removed(n2, s1.Key()) })
+	if len(n1.service) != 0 || len(n2.service) != 0 {
+		t.Fatalf("services left: %d %d", len(n1.service), len(n2.service))
+	}
+}
+
+func TestDiscoveryGiveUp(t *testing.T) {
+	dir := t.TempDir()
+	n, _ := testDiscovery(t, dir, ServerInfo{Area: 9, Type: 1, ID: 1}, &ServiceConfig{
+		ServerInfo:    ServerInfo{Area: 9, Type: 2},
+		AutoReconnect: true,
+		Restart:       RestartPolicy{MaxRetries: 1},
+	})
+
+	// 已经注册但是无法连接的服务节点
+	peer := &Endpoint{ServerInfo: ServerInfo{Area: 9, Type: 2, ID: 1}, Protocol: "pipe", Path: t.Name() + "/missing"}
+	registry, err := NewFileRegistry(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.Register(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	var started, gaveUp int
+	OnServiceState(func(e *ServiceEvent) {
+		if e.Config.IsClient && e.Config.Key() == peer.Key() {
+			switch e.State {
+			case ServiceStarted:
+				started++
+			case ServiceGaveUp:
+				gaveUp++
+			}
+		}
+	})
+
+	// 放弃重连后服务节点仍然存活，下次刷新时重新连接
+	deadline := time.Now().Add(5 * time.Second)
+	for started < 2 || gaveUp < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout: started %d gave up %d", started, gaveUp)
+		}
+		_ = registry.Register(peer)
+		n.Update()
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,79 @@
+package network
+
+import (
+	"time"
+)
+
+const drainFlushTimeout = 5 * time.Second // 优雅关闭到期后等待发送队列清空的最长时间
+
+// GoAway 服务即将关闭的通知消息，见 ServiceConfig.GoAwayMsgID
+// 客户端收到后应该重新连接其它服务
+type GoAway struct {
+	Timeout int // 多少秒后关闭连接
+}
+
+// drain 服务端优雅关闭
+// 停止接收新连接后通知所有连接服务即将关闭，连接在 ServiceConfig.DrainTimeout 内继续正常收发消息，
+// 到期后发送完队列中的消息再关闭连接，仍然没有关闭的连接在 drainFlushTimeout 后强制关闭
+type drain struct {
+	sc       *ServiceConfig
+	deadline time.Time // 到期后发送完队列中的消息再关闭连接
+	force    time.Time // 到期后强制关闭连接
+}
+
+// start 开始关闭所有连接，没有配置 ServiceConfig.DrainTimeout 时立即关闭
+func (d *drain) start(sessions map[*Session]struct{}) {
+	if d.sc.DrainTimeout <= 0 {
+		for v := range sessions {
+			v.CloseWithReason(CloseShutdown)
+		}
+		return
+	}
+
+	d.deadline = time.Now().Add(d.sc.DrainTimeout)
+	d.force = d.deadline.Add(drainFlushTimeout)
+	if d.sc.GoAwayMsgID != 0 {
+		msg := &GoAway{Timeout: int(d.sc.DrainTimeout / time.Second)}
+		for v := range sessions {
+			_ = v.sendHead(&MsgHead{MsgID: d.sc.GoAwayMsgID}, msg)
+		}
+	}
+}
+
+// update 检查是否到期，在服务的 Update 中调用
+func (d *drain) update(sessions map[*Session]struct{}) {
+	if d.force.IsZero() {
+		return
+	}
+	now := time.Now()
+	if now.After(d.force) {
+		d.force = time.Time{}
+		for v := range sessions {
+			v.CloseWithReason(CloseShutdown)
+		}
+		return
+	}
+	if !d.deadline.IsZero() && now.After(d.deadline) {
+		d.deadline = time.Time{}
+		for v := range sessions {
+			v.closeAfterFlush(CloseShutdown)
+		}
+	}
+}
+
+// closeAfterFlush 发送完队列中的消息后关闭连接
+// 结束标记放在溢出队列的最后，溢出队列中的数据包全部放入发送队列后再放入结束标记，之后发送的数据包直接丢弃
+func (s *Session) closeAfterFlush(reason CloseReason) {
+	s.setCloseReason(reason)
+	select {
+	case <-s.closeSign:
+		return
+	default:
+	}
+	if s.flushing {
+		return
+	}
+	s.flushing = true
+	s.sendSpill = append(s.sendSpill, nil)
+	s.flushSpill()
+}
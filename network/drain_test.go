@@ -0,0 +1,43 @@
+package network
+
+import (
+	"testing"
+)
+
+func TestCloseAfterFlush(t *testing.T) {
+	testMsgHandler(t)
+	_, sc := testConfigs(t)
+	sc.MaxSend, sc.SendPolicy = 4, PolicySpill
+	if err := sc.init(); err != nil {
+		t.Fatal(err)
+	}
+	s, recv := testPipeSession(t, sc)
+
+	// 溢出队列中的数据包全部发送后再关闭连接
+	for i := 0; i < 50; i++ {
+		if err := s.sendHead(&MsgHead{MsgID: 1}, &pipeMsg{Text: "flush"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.sendSpill) == 0 {
+		t.Fatal("send spill empty")
+	}
+	go s.sendMsg()
+	s.closeAfterFlush(CloseShutdown)
+	// 结束标记之后发送的数据包直接丢弃
+	if err := s.sendHead(&MsgHead{MsgID: 1}, &pipeMsg{Text: "late"}); err != ErrSessionClosed {
+		t.Fatalf("send after close: %v", err)
+	}
+	for len(s.sendSpill) > 0 {
+		s.flushSpill()
+	}
+	<-s.sendDone
+
+	if n := <-recv; n != 50 {
+		t.Fatalf("received %d packets before close, want 50", n)
+	}
+	s.releaseSpill()
+	if s.CloseReason() != CloseShutdown || len(s.send) != 0 {
+		t.Fatalf("reason %v send %d", s.CloseReason(), len(s.send))
+	}
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube/encoding"
+)
+
+const (
+	fragmentHeadRoom   = 32 // 计算默认分片大小时为消息头预留的字节数
+	DefaultReassembleN = 64 // 默认的每个连接分片重组缓存最大字节数为 Configuration.MaxMsgLen 的倍数
+)
+
+// pack 封包，消息数据超过 ServiceConfig.FragmentSize 时拆分成多个分片
+// head 消息头
+// et 消息数据的编码类型
+// data 序列化后的消息数据
+func (sc *ServiceConfig) pack(head *MsgHead, et encoding.EncodeType, data []byte) ([][]byte, error) {
+	size := sc.FragmentSize
+	if size <= 0 || len(data) <= size {
+		pkg, err := sc.codec.Marshal(head, et, data)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{pkg}, nil
+	}
+
+	total := (len(data) + size - 1) / size
+	if total > math.MaxUint16 {
+		return nil, errors.New("message too long")
+	}
+	packs := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		h := *head
+		h.Flags |= FlagFragment
+		h.FragIndex = uint16(i)
+		h.FragTotal = uint16(total)
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		pkg, err := sc.codec.Marshal(&h, et, data[i*size:end])
+		if err != nil {
+			for _, v := range packs {
+				putBuffer(bytes.NewBuffer(v))
+			}
+			return nil, err
+		}
+		packs = append(packs, pkg)
+	}
+	return packs, nil
+}
+
+// fragment 分片重组缓存
+type fragment struct {
+	total uint16
+	next  uint16 // 下一个分片序号
+	data  []byte
+	drop  bool // 不允许处理的消息，丢弃所有分片，不缓存数据
+}
+
+// acceptFragment 收到第一个分片时检查是否接收这个消息
+// 消息号没有注册且不能转发，或者认证通过之前不允许处理时丢弃整个消息，避免未认证的连接占用重组缓存
+func (s *Session) acceptFragment(head *MsgHead) bool {
+	if !s.allowed(head.MsgID) {
+		log.WithField("SessionInfo", s).Tracef("drop fragments before auth: %d", head.MsgID)
+		return false
+	}
+	if s.SC.handler.registered(head.MsgID) || s.SC.forwardRule(head.MsgID) != nil ||
+		(head.HasFlag(FlagForward) && s.SC.IsClient) {
+		return true
+	}
+	log.WithField("SessionInfo", s).Tracef("drop fragments of unregistered msgID: %d", head.MsgID)
+	return false
+}
+
+// reassemble 分片重组
+// head 分片的消息头
+// data 分片数据
+// 返回重组后的完整消息数据，还没有收到全部分片时返回nil
+func (s *Session) reassemble(head *MsgHead, data []byte) ([]byte, error) {
+	if s.frags == nil {
+		s.frags = make(map[SessionKey]*fragment)
+	}
+	// 网关转发的消息来自不同的客户端，分别重组
+	f := s.frags[head.Client]
+	if head.FragIndex == 0 && f != nil {
+		s.fragLen -= len(f.data)
+		f = nil
+	}
+	if f == nil {
+		if head.FragIndex != 0 || head.FragTotal < 2 {
+			return nil, errors.New("fragment out of order")
+		}
+		f = &fragment{total: head.FragTotal, drop: !s.acceptFragment(head)}
+		s.frags[head.Client] = f
+	}
+	if f.drop {
+		if head.FragIndex != f.next || head.FragTotal != f.total {
+			delete(s.frags, head.Client)
+			return nil, errors.New("fragment out of order")
+		}
+		if f.next++; f.next == f.total {
+			delete(s.frags, head.Client)
+		}
+		return nil, nil
+	}
+	if head.FragIndex != f.next || head.FragTotal != f.total {
+		s.fragLen -= len(f.data)
+		delete(s.frags, head.Client)
+		return nil, errors.New("fragment out of order")
+	}
+	if s.fragLen+len(data) > s.SC.MaxReassembleLen {
+		s.fragLen -= len(f.data)
+		delete(s.frags, head.Client)
+		return nil, errors.New("fragment too large")
+	}
+
+	f.data = append(f.data, data...)
+	f.next++
+	s.fragLen += len(data)
+	if f.next < f.total {
+		return nil, nil
+	}
+
+	s.fragLen -= len(f.data)
+	delete(s.frags, head.Client)
+	head.Flags &^= FlagFragment
+	head.FragIndex = 0
+	head.FragTotal = 0
+	return f.data, nil
+}
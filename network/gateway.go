@@ -0,0 +1,189 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube/encoding"
+)
+
+// ForwardRule 网关转发规则
+// 收到未注册的消息时，消息号在 [MinMsgID, MaxMsgID] 范围内的消息不做反序列化，直接转发给指定地区和类型的服务
+type ForwardRule struct {
+	MinMsgID uint32 // 最小消息号
+	MaxMsgID uint32 // 最大消息号
+	Area     uint8  // 目标服务地区
+	Type     uint8  // 目标服务类型
+	Hash     bool   // 根据客户端连接标识一致性hash选择目标服务，同一个客户端总是转发给同一个服务，否则轮询
+}
+
+func (f *ForwardRule) String() string {
+	return fmt.Sprintf("MsgID:[%v,%v], Area:%v, Type:%v, Hash:%v", f.MinMsgID, f.MaxMsgID, f.Area, f.Type, f.Hash)
+}
+
+func (f *ForwardRule) init() error {
+	if f.MinMsgID > f.MaxMsgID {
+		return fmt.Errorf("forward rule error: %v", f)
+	}
+	return nil
+}
+
+// forwardRule 根据消息号查找转发规则
+func (sc *ServiceConfig) forwardRule(msgID uint32) *ForwardRule {
+	for _, v := range sc.Forward {
+		if msgID >= v.MinMsgID && msgID <= v.MaxMsgID {
+			return v
+		}
+	}
+	return nil
+}
+
+// sendRaw 发送消息，消息数据不做反序列化，不执行过滤器
+// head 消息头
+// et 消息数据的编码类型
+// data 序列化后的消息数据
+func (s *Session) sendRaw(head *MsgHead, et encoding.EncodeType, data []byte) error {
+	pkgs, err := s.SC.pack(head, et, data)
+	if err != nil {
+		return err
+	}
+	packs := make([]*sendPack, len(pkgs))
+	for i, v := range pkgs {
+		packs[i] = &sendPack{data: v}
+	}
+	return s.push(packs...)
+}
+
+// trusted 是否是可信的网关链路，只有可信链路上的消息可以携带网关转发的客户端连接标识和用户ID
+// 本服务主动建立的客户端连接和配置了 ServiceConfig.TrustForward 的服务端连接是可信的
+func (s *Session) trusted() bool {
+	return s.SC.IsClient || s.SC.TrustForward
+}
+
+// untrust 清除不可信连接伪造的转发标记，客户端连接标识和用户ID
+func (s *Session) untrust(head *MsgHead) {
+	if s.trusted() {
+		return
+	}
+	head.Flags &^= FlagForward | FlagUser
+	head.Client, head.UID = 0, 0
+}
+
+// sendFrame 转发收到的数据包，只修改消息头，消息数据直接复制，不解压也不重新序列化
+// from 收到数据包的连接
+// pkg 收到的数据包，分片重组后为nil
+// head 新的消息头
+// et data 消息数据的编码类型和解压后的消息数据，不能直接转发时重新封包
+func (s *Session) sendFrame(from *Session, pkg []byte, head *MsgHead, et encoding.EncodeType, data []byte) error {
+	if b := s.rehead(from, pkg, head, et); b != nil {
+		return s.push(&sendPack{data: b})
+	}
+	return s.sendRaw(head, et, data)
+}
+
+// rehead 复制数据包并替换消息头，标记位中的 FlagCompressed 以原数据包为准
+// 两个连接的数据包格式不同、数据包已经重组、需要分片或者超过最大长度时返回nil
+func (s *Session) rehead(from *Session, pkg []byte, head *MsgHead, et encoding.EncodeType) []byte {
+	src, ok := from.SC.codec.(*DefaultCodec)
+	if !ok || pkg == nil {
+		return nil
+	}
+	dst, ok := s.SC.codec.(*DefaultCodec)
+	if !ok || !src.sameFormat(dst) {
+		return nil
+	}
+
+	n := int(src.Pkg.lenMsgLen)
+	flags := uint8(src.Msg.endian.Uint16(pkg[n:]) >> 8)
+	if flags&FlagFragment != 0 {
+		return nil
+	}
+	body := pkg[n+src.Msg.HeadLen(&MsgHead{Flags: flags}):]
+	if s.SC.FragmentSize > 0 && len(body) > s.SC.FragmentSize {
+		return nil
+	}
+
+	flags = head.Flags&^(FlagCompressed|FlagFragment) | flags&FlagCompressed
+	l := dst.Msg.HeadLen(&MsgHead{Flags: flags})
+	bs := getBytesN(n + l + len(body))
+	dst.Msg.putHead(bs[n:], head, et, flags)
+	copy(bs[n+l:], body)
+	if _, err := dst.Pkg.Encode(bs); err != nil {
+		putBuffer(bytes.NewBuffer(bs))
+		return nil
+	}
+	return bs
+}
+
+// forward 网关转发客户端消息给后端服务
+// 消息数据不做反序列化，收到的数据包只替换消息头，增加客户端连接标识和用户ID后转发，见 sendFrame
+// pkg 收到的数据包，分片重组后为nil
+// 返回是否有匹配的转发规则
+func (s *Session) forward(pkg []byte, head *MsgHead, et encoding.EncodeType, data []byte) bool {
+	rule := s.SC.forwardRule(head.MsgID)
+	if rule == nil {
+		return false
+	}
+
+	var backend *Session
+	if rule.Hash {
+		backend = gRouter.Hash(rule.Area, rule.Type, strconv.FormatUint(uint64(s.Key()), 10))
+	} else {
+		backend = gRouter.RoundRobin(rule.Area, rule.Type)
+	}
+	if backend == nil {
+		log.WithField("SessionInfo", s).Warningf("forward msgID %v error: %v", head.MsgID, ErrServerNotFound)
+		return true
+	}
+
+	head.Flags |= FlagForward
+	head.Client = s.Key()
+	if s.uid != 0 {
+		head.Flags |= FlagUser
+		head.UID = s.uid
+	}
+	if err := backend.sendFrame(s, pkg, head, et, data); err != nil {
+		log.WithField("SessionInfo", s).Errorf("forward msgID %v error: %v", head.MsgID, err)
+	}
+	return true
+}
+
+// relay 网关把后端服务的返回消息转发给客户端，去掉客户端连接标识和用户ID，见 sendFrame
+func (s *Session) relay(pkg []byte, head *MsgHead, et encoding.EncodeType, data []byte) {
+	var client *Session
+	if head.Client == 0 && head.HasFlag(FlagUser) {
+		client = SessionByUser(head.UID)
+	} else {
+		client = gRouter.Session(head.Client)
+	}
+	if client == nil || len(client.SC.Forward) == 0 {
+		log.WithField("SessionInfo", s).Tracef("relay msgID %v: client %v uid %v not found", head.MsgID, head.Client, head.UID)
+		return
+	}
+
+	head.Flags &^= FlagForward | FlagUser
+	head.Client, head.UID = 0, 0
+	if err := client.sendFrame(s, pkg, head, et, data); err != nil {
+		log.WithField("SessionInfo", s).Errorf("relay msgID %v error: %v", head.MsgID, err)
+	}
+}
+
+// Forward 后端服务通过网关给客户端发送消息
+// client 客户端在网关上的连接标识
+// msgID 消息号
+// msg 消息数据
+// 线程不安全，必须在module节点上执行
+func (s *Session) Forward(client SessionKey, msgID uint32, msg interface{}) error {
+	return s.sendHead(&MsgHead{Flags: FlagForward, MsgID: msgID, Client: client}, msg)
+}
+
+// SendClient 后端服务通过网关给当前消息的客户端发送消息
+// msgID 消息号
+// msg 消息数据
+// 只能在网关转发的消息处理方法中调用，线程不安全，必须在module节点上执行
+func (c *Context) SendClient(msgID uint32, msg interface{}) error {
+	return c.Forward(c.Client, msgID, msg)
+}
@@ -0,0 +1,177 @@
+package network
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	// 网关没有注册消息，收到的消息都按规则转发
+	testMsgHandler(t)
+	backend, client := GetMsgHandler(t.Name()+"/backend"), GetMsgHandler(t.Name()+"/client")
+	t.Cleanup(func() {
+		delete(gMsgHandlers, t.Name()+"/backend")
+		delete(gMsgHandlers, t.Name()+"/client")
+	})
+	var from SessionKey
+	var uid uint64
+	backend.SetHandlerFunc(10, new(pipeMsg), func(c *Context) {
+		from, uid = c.Client, c.UID
+		_ = c.SendClient(11, &pipeMsg{Text: c.Msg.(*pipeMsg).Text + " pong"})
+	})
+	var reply string
+	client.SetHandlerFunc(11, new(pipeMsg), func(c *Context) {
+		reply = c.Msg.(*pipeMsg).Text
+	})
+
+	// 客户端 -> 网关 -> 后端服务，每个服务使用不同的服务标识，避免同一个进程中的连接标识重复
+	configs := []*ServiceConfig{
+		{
+			ServerInfo:   ServerInfo{Area: 1, Type: 3, ID: 1},
+			Protocol:     "pipe",
+			Path:         t.Name() + "/backend",
+			Handler:      t.Name() + "/backend",
+			TrustForward: true,
+		},
+		{
+			ServerInfo: ServerInfo{Area: 1, Type: 1, ID: 1},
+			Protocol:   "pipe",
+			Path:       t.Name() + "/gateway",
+			Handler:    t.Name(),
+			Forward:    []*ForwardRule{{MinMsgID: 10, MaxMsgID: 19, Area: 1, Type: 3}},
+		},
+		{
+			ServerInfo: ServerInfo{Area: 1, Type: 3, ID: 2},
+			Protocol:   "pipe",
+			Path:       t.Name() + "/backend",
+			Handler:    t.Name(),
+			IsClient:   true,
+			ClientNum:  1,
+		},
+		{
+			ServerInfo: ServerInfo{Area: 1, Type: 1, ID: 2},
+			Protocol:   "pipe",
+			Path:       t.Name() + "/gateway",
+			Handler:    t.Name() + "/client",
+			IsClient:   true,
+			ClientNum:  1,
+		},
+	}
+	nets := []*Network{NewNetwork(), NewNetwork(), NewNetwork(), NewNetwork()}
+	for i, sc := range configs {
+		if err := sc.init(); err != nil {
+			t.Fatal(err)
+		}
+		s := nets[i].newService(sc)
+		if s == nil {
+			t.Fatal("service start error")
+		}
+		t.Cleanup(func() { s.Shutdown() })
+	}
+	gwClient := nets[2].service[configs[2].Key()].(*TCPClient)
+	app := nets[3].service[configs[3].Key()].(*TCPClient)
+
+	sent := false
+	deadline := time.Now().Add(5 * time.Second)
+	for reply == "" && time.Now().Before(deadline) {
+		for _, n := range nets {
+			n.Update()
+		}
+		if !sent && len(gwClient.sessions) > 0 {
+			for s := range app.sessions {
+				// 客户端伪造的转发标记在网关上被清除
+				err := s.sendHead(&MsgHead{Flags: FlagForward | FlagUser, MsgID: 10, Client: 123, UID: 456},
+					&pipeMsg{Text: "ping"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				sent = true
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if reply != "ping pong" {
+		t.Fatalf("reply: %q", reply)
+	}
+	if s := gRouter.Session(from); s == nil || s.SC != configs[1] {
+		t.Errorf("forwarded client: %v", from)
+	}
+	if uid != 0 {
+		t.Errorf("forwarded uid: %v", uid)
+	}
+}
+
+func TestMsgUID(t *testing.T) {
+	head := &MsgHead{Flags: FlagForward | FlagUser, Client: 1, UID: 2}
+	s := NewSession(&ServiceConfig{})
+	s.uid = 3
+	if uid := s.msgUID(head); uid != 3 {
+		t.Errorf("untrusted session uid: %v", uid)
+	}
+	s.SC.TrustForward = true
+	if uid := s.msgUID(head); uid != 2 {
+		t.Errorf("trusted session uid: %v", uid)
+	}
+}
+
+func TestForwardFrame(t *testing.T) {
+	client := &ServiceConfig{Compress: "gzip", CompressThreshold: 16}
+	backend := &ServiceConfig{ServerInfo: ServerInfo{Area: 1, Type: 3, ID: 1}, IsClient: true}
+	for _, v := range []*ServiceConfig{client, backend} {
+		if err := v.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs, bs := NewSession(client), NewSession(backend)
+
+	// 客户端伪造转发标记，消息数据已压缩
+	text := strings.Repeat("forward", 100)
+	et, data, err := encodeMsg(&pipeMsg{Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := client.codec.Marshal(&MsgHead{Flags: FlagForward | FlagRequest, MsgID: 10, Seq: 7, Client: 99}, et, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, et, _, err := client.codec.Unmarshal(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.untrust(head)
+	head.Flags |= FlagForward | FlagUser
+	head.Client, head.UID = cs.Key(), 5
+
+	// 只替换消息头，压缩后的消息数据原样复制
+	out := bs.rehead(cs, pkg, head, et)
+	if out == nil {
+		t.Fatal("frame not forwarded")
+	}
+	n := int(gPkgParser.lenMsgLen)
+	body := pkg[n+client.codec.(*DefaultCodec).Msg.HeadLen(&MsgHead{Flags: FlagForward | FlagRequest}):]
+	if !bytes.HasSuffix(out, body) {
+		t.Fatal("message data re-encoded")
+	}
+	h, _, got, err := backend.codec.Unmarshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.MsgID != 10 || h.Seq != 7 || h.Client != cs.Key() || h.UID != 5 || !h.HasFlag(FlagRequest) {
+		t.Fatalf("head: %+v", h)
+	}
+	msg := new(pipeMsg)
+	if err = unmarshalMsg(h.MsgID, et, got, msg); err != nil || msg.Text != text {
+		t.Fatalf("data: %v %d", err, len(msg.Text))
+	}
+
+	// 数据包格式不同时重新封包
+	other := &ServiceConfig{MsgIDLen: 4, IsClient: true}
+	if err = other.init(); err != nil {
+		t.Fatal(err)
+	}
+	if NewSession(other).rehead(cs, pkg, head, et) != nil {
+		t.Fatal("frame forwarded between different formats")
+	}
+}
@@ -0,0 +1,130 @@
+package network
+
+// groupsValue 连接所在的分组
+var groupsValue = NewSessionValue[map[*Group]struct{}]("network.groups")
+
+// Group 连接分组，如房间、频道，组内发送消息时只序列化一次，见 Broadcast
+// 连接关闭后在 AfterClosed 之后自动离开所在的所有分组
+// 线程不安全，必须在module节点上使用
+type Group struct {
+	sessions map[*Session]struct{}
+	list     []*Session // 发送消息时复用
+	keys     map[string]interface{}
+}
+
+func NewGroup() *Group {
+	return &Group{
+		sessions: make(map[*Session]struct{}),
+	}
+}
+
+// Add 连接加入分组，已经关闭的连接不能加入
+func (g *Group) Add(s *Session) bool {
+	if s.CloseReason() != CloseNone {
+		return false
+	}
+	g.sessions[s] = struct{}{}
+	s.groups()[g] = struct{}{}
+	return true
+}
+
+// Remove 连接离开分组
+func (g *Group) Remove(s *Session) {
+	if _, ok := g.sessions[s]; !ok {
+		return
+	}
+	delete(g.sessions, s)
+	delete(s.groups(), g)
+}
+
+// Clear 所有连接离开分组
+func (g *Group) Clear() {
+	for s := range g.sessions {
+		g.Remove(s)
+	}
+}
+
+// Has 是否在分组中
+func (g *Group) Has(s *Session) bool {
+	_, ok := g.sessions[s]
+	return ok
+}
+
+// Len 分组中的连接数量
+func (g *Group) Len() int {
+	return len(g.sessions)
+}
+
+// Range 遍历分组中的连接，f 返回false时停止遍历，f 中可以调用 Remove
+func (g *Group) Range(f func(s *Session) bool) {
+	for s := range g.sessions {
+		if !f(s) {
+			return
+		}
+	}
+}
+
+// Set 设置分组的自定义数据
+func (g *Group) Set(key string, value interface{}) {
+	if g.keys == nil {
+		g.keys = make(map[string]interface{})
+	}
+	g.keys[key] = value
+}
+
+// Get 获取分组的自定义数据
+func (g *Group) Get(key string) (value interface{}, exists bool) {
+	value, exists = g.keys[key]
+	return
+}
+
+// Send 给分组中的所有连接发送消息
+func (g *Group) Send(msgID uint32, msg interface{}) error {
+	return g.SendExcept(nil, msgID, msg)
+}
+
+// SendExcept 给分组中除 except 以外的所有连接发送消息
+func (g *Group) SendExcept(except *Session, msgID uint32, msg interface{}) error {
+	list := g.list[:0]
+	for s := range g.sessions {
+		if s != except {
+			list = append(list, s)
+		}
+	}
+	err := Broadcast(list, msgID, msg)
+	for i := range list {
+		list[i] = nil
+	}
+	g.list = list[:0]
+	return err
+}
+
+// groups 连接所在的分组
+func (s *Session) groups() map[*Group]struct{} {
+	if m, ok := groupsValue.Get(s.context); ok {
+		return m
+	}
+	m := make(map[*Group]struct{})
+	groupsValue.Set(s.context, m)
+	return m
+}
+
+// Groups 获取连接所在的所有分组，需要在module节点上调用
+func (s *Session) Groups() []*Group {
+	m := groupsValue.Value(s.context)
+	if len(m) == 0 {
+		return nil
+	}
+	ret := make([]*Group, 0, len(m))
+	for g := range m {
+		ret = append(ret, g)
+	}
+	return ret
+}
+
+// leaveGroups 连接关闭后离开所在的所有分组
+func (s *Session) leaveGroups() {
+	for g := range groupsValue.Value(s.context) {
+		g.Remove(s)
+	}
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"testing"
+)
+
+// testSessions 没有连接的会话，发送的数据包留在发送队列中
+func testSessions(t *testing.T, n int) []*Session {
+	testMsgHandler(t)
+	sc, _ := testConfigs(t)
+	if err := sc.init(); err != nil {
+		t.Fatal(err)
+	}
+	ret := make([]*Session, n)
+	for i := range ret {
+		ret[i] = NewSession(sc)
+	}
+	return ret
+}
+
+func TestGroup(t *testing.T) {
+	s := testSessions(t, 3)
+	g1, g2 := NewGroup(), NewGroup()
+	for _, v := range s {
+		if !g1.Add(v) {
+			t.Fatal("add failed")
+		}
+	}
+	g2.Add(s[0])
+	if g1.Len() != 3 || g2.Len() != 1 || !g2.Has(s[0]) || g2.Has(s[1]) {
+		t.Fatalf("len %d %d", g1.Len(), g2.Len())
+	}
+	if len(s[0].Groups()) != 2 || len(s[1].Groups()) != 1 {
+		t.Fatalf("groups %d %d", len(s[0].Groups()), len(s[1].Groups()))
+	}
+
+	// 遍历时可以离开分组
+	g1.Range(func(v *Session) bool {
+		if v == s[1] {
+			g1.Remove(v)
+		}
+		return true
+	})
+	if g1.Has(s[1]) || s[1].Groups() != nil || g1.Len() != 2 {
+		t.Fatal("session not removed")
+	}
+	// 重复离开没有影响
+	g1.Remove(s[1])
+	if g1.Len() != 2 {
+		t.Fatalf("len %d after repeated remove", g1.Len())
+	}
+
+	g1.Set("room", 1)
+	if v, ok := g1.Get("room"); !ok || v != 1 {
+		t.Fatalf("get %v %v", v, ok)
+	}
+	if _, ok := g2.Get("room"); ok {
+		t.Fatal("value shared between groups")
+	}
+
+	g1.Clear()
+	if g1.Len() != 0 || len(s[0].Groups()) != 1 || s[2].Groups() != nil {
+		t.Fatal("group not cleared")
+	}
+
+	// 已经关闭的连接不能加入
+	s[1].setCloseReason(CloseNormal)
+	if g1.Add(s[1]) || g1.Has(s[1]) {
+		t.Fatal("closed session added")
+	}
+}
+
+func TestGroupSend(t *testing.T) {
+	s := testSessions(t, 3)
+	g := NewGroup()
+	for _, v := range s {
+		g.Add(v)
+	}
+	if err := g.SendExcept(s[0], 1, &pipeMsg{Text: "except"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Send(1, &pipeMsg{Text: "all"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range s {
+		want := 2
+		if i == 0 {
+			want = 1
+		}
+		if len(v.send) != want {
+			t.Fatalf("session %d queued %d, want %d", i, len(v.send), want)
+		}
+		v.drainSend()
+	}
+	if len(g.list) != 0 {
+		t.Fatalf("list not reset: %d", len(g.list))
+	}
+}
+
+func TestGroupLeaveOnClose(t *testing.T) {
+	testMsgHandler(t)
+	server, client := testConfigs(t)
+	p := newPipeTest(t, server, client)
+	g := NewGroup()
+	// AfterClosed 中仍然在分组中
+	var member bool
+	testFilter(server, &FilterFunc{AfterClosed: func(c *Context) bool {
+		member = g.Has(c.Session)
+		return true
+	}})
+	ss, cs := p.connected()
+	other := NewSession(server)
+	g.Add(ss)
+	g.Add(other)
+
+	_ = cs.Close()
+	p.wait("closed", func() bool { return len(p.server.sessions) == 0 })
+	if !member {
+		t.Fatal("left group before AfterClosed")
+	}
+	if g.Has(ss) || g.Len() != 1 || ss.Groups() != nil {
+		t.Fatalf("closed session still in group: %d", g.Len())
+	}
+}
@@ -0,0 +1,63 @@
+package network
+
+import "sync"
+
+// SetHandle 设置消息处理方法，消息类型在编译时确定，创建消息时不使用反射，处理方法中不需要类型断言
+// m 消息注册表，见 GetMsgHandler
+// msgID 消息号
+// pool 是否使用对象池创建消息，消息处理完成后（包括被过滤器拒绝及作为rpc返回时）清零并放回对象池，不能保留消息的引用
+// f 消息处理方法
+func SetHandle[T interface{ *M }, M any](m *MsgHandler, msgID uint32, pool bool, f func(c *Context, msg T)) {
+	if f == nil {
+		m.SetHandler(msgID, T(new(M)), nil)
+		return
+	}
+	m.SetHandler(msgID, T(new(M)), HandlerWrapper(func(c *Context) {
+		f(c, c.Msg.(T))
+	}))
+	info := m.messages[msgID]
+	if !pool {
+		info.newMsg = func() interface{} {
+			return T(new(M))
+		}
+		return
+	}
+	p := &sync.Pool{New: func() interface{} {
+		return T(new(M))
+	}}
+	info.newMsg = p.Get
+	info.freeMsg = func(msg interface{}) {
+		v := msg.(T)
+		var zero M
+		*v = zero
+		p.Put(v)
+	}
+}
+
+// Handle 在默认注册表中设置消息处理方法，例如 Handle(1, func(c *Context, m *Ping) {})
+// msgID 消息号
+// f 消息处理方法
+func Handle[T interface{ *M }, M any](msgID uint32, f func(c *Context, msg T)) {
+	SetHandle(gMsgHandler, msgID, false, f)
+}
+
+// HandlePool 在默认注册表中设置消息处理方法，使用对象池创建消息，处理方法中不能保留消息的引用
+// msgID 消息号
+// f 消息处理方法
+func HandlePool[T interface{ *M }, M any](msgID uint32, f func(c *Context, msg T)) {
+	SetHandle(gMsgHandler, msgID, true, f)
+}
+
+// Reply 返回rpc调用的结果，消息类型在编译时检查，见 Context.Reply
+// msgID 消息号
+// msg 消息结构体指针
+func Reply[T interface{ *M }, M any](c *Context, msgID uint32, msg T) {
+	c.Reply(msgID, msg)
+}
+
+// Send 发送消息，消息类型在编译时检查，见 Session.Send
+// msgID 消息号
+// msg 消息结构体指针
+func Send[T interface{ *M }, M any](s *Session, msgID uint32, msg T) {
+	s.Send(msgID, msg)
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"testing"
+)
+
+type poolMsg struct {
+	Text string
+	N    int `json:",omitempty"`
+}
+
+// testProcess 服务端连接处理一个客户端发送的消息，seq不为0时作为rpc请求
+func testProcess(t *testing.T, s *Session, msgID, seq uint32, msg interface{}) {
+	t.Helper()
+	head := &MsgHead{MsgID: msgID}
+	if seq != 0 {
+		head.Flags, head.Seq = FlagRequest, seq
+	}
+	et, data, err := encodeMsg(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := s.SC.codec.Marshal(head, et, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.process(pkg)
+}
+
+// testSession 使用 testMsgHandler 注册表的服务端连接，发送的数据包留在发送队列中
+func testSession(t *testing.T) *Session {
+	sc, _ := testConfigs(t)
+	if err := sc.init(); err != nil {
+		t.Fatal(err)
+	}
+	return NewSession(sc)
+}
+
+func TestSetHandle(t *testing.T) {
+	for _, pool := range []bool{false, true} {
+		t.Run(map[bool]string{false: "new", true: "pool"}[pool], func(t *testing.T) {
+			h := testMsgHandler(t)
+			var got []*poolMsg
+			SetHandle(h, 1, pool, func(c *Context, msg *poolMsg) {
+				// 每次处理的消息都是重置后的零值再反序列化
+				if msg.N != len(got)%2 || msg.Text == "" {
+					t.Errorf("pool %v: dispatch %d got %+v", pool, len(got), *msg)
+				}
+				got = append(got, msg)
+			})
+			s := testSession(t)
+			for i := 0; i < 20; i++ {
+				testProcess(t, s, 1, 0, &poolMsg{Text: "m", N: i % 2})
+				if s.context.Msg != nil && pool {
+					t.Fatal("context keeps pooled message")
+				}
+			}
+
+			// 使用对象池时复用消息，否则每次创建新的消息
+			seen := make(map[*poolMsg]struct{})
+			for _, v := range got {
+				seen[v] = struct{}{}
+			}
+			if reused := len(seen) < len(got); reused != pool {
+				t.Fatalf("pool %v: %d messages for %d dispatches", pool, len(seen), len(got))
+			}
+			if pool && *got[len(got)-1] != (poolMsg{}) {
+				t.Fatalf("pooled message not reset: %+v", *got[len(got)-1])
+			}
+			if !pool && *got[len(got)-1] != (poolMsg{Text: "m", N: 1}) {
+				t.Fatalf("message changed: %+v", *got[len(got)-1])
+			}
+		})
+	}
+}
+
+func TestHandlePoolReply(t *testing.T) {
+	h := testMsgHandler(t)
+	// 返回收到的消息，消息放回对象池之前已经序列化
+	SetHandle(h, 1, true, func(c *Context, msg *poolMsg) {
+		msg.N++
+		Reply(c, 2, msg)
+	})
+	s := testSession(t)
+	for i := 1; i <= 3; i++ {
+		testProcess(t, s, 1, uint32(i), &poolMsg{Text: "echo", N: i})
+	}
+	for i := 1; i <= 3; i++ {
+		p := <-s.send
+		head, et, data, err := s.SC.codec.Unmarshal(p.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := new(poolMsg)
+		if err = unmarshalMsg(head.MsgID, et, data, msg); err != nil {
+			t.Fatal(err)
+		}
+		if head.MsgID != 2 || head.Seq != uint32(i) || !head.HasFlag(FlagResponse) || *msg != (poolMsg{Text: "echo", N: i + 1}) {
+			t.Fatalf("reply %d: %+v %+v", i, head, msg)
+		}
+		p.release()
+	}
+}
+
+func TestHandle(t *testing.T) {
+	const plain, pooled = 9001, 9002
+	t.Cleanup(func() {
+		delete(gMsgHandler.messages, plain)
+		delete(gMsgHandler.messages, pooled)
+	})
+	Handle(plain, func(c *Context, msg *poolMsg) {})
+	HandlePool(pooled, func(c *Context, msg *poolMsg) {})
+
+	// 默认注册表中注册，只有 HandlePool 使用对象池
+	for id, pool := range map[uint32]bool{plain: false, pooled: true} {
+		msg, ok := CreateMessage(id).(*poolMsg)
+		if !ok {
+			t.Fatalf("msgID %d: %T", id, CreateMessage(id))
+		}
+		msg.Text = "free"
+		if freed := gMsgHandler.freeMessage(id, msg); freed != pool {
+			t.Fatalf("msgID %d: freed %v", id, freed)
+		}
+		if pool && msg.Text != "" {
+			t.Fatal("freed message not reset")
+		}
+	}
+}
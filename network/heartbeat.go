@@ -0,0 +1,82 @@
+package network
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/skeletongo/cube/encoding"
+)
+
+const DefaultPingInterval = 10 * time.Second // 默认的心跳间隔
+
+// Ping 心跳消息，见 ServiceConfig.PingMsgID
+// 客户端定时发送，服务端收到后原样返回，不需要注册，也不经过过滤器和中间件
+type Ping struct {
+	Time int64 // 客户端发送时间，纳秒
+	Pong bool  // 是否是服务端的返回
+}
+
+// RTT 最近一次心跳的往返时间，只有客户端连接会测量
+func (s *Session) RTT() time.Duration {
+	return s.rtt
+}
+
+// LastActive 最后一次收到消息的时间
+func (s *Session) LastActive() time.Time {
+	return s.lastRecv
+}
+
+// heartbeat 处理心跳消息
+func (s *Session) heartbeat(et encoding.EncodeType, data []byte) {
+	ping := new(Ping)
+	if err := unmarshalMsg(s.SC.PingMsgID, et, data, ping); err != nil {
+		log.WithField("SessionInfo", s).Errorf("ping unmarshal error: %v", err)
+		return
+	}
+	if ping.Pong {
+		if s.SC.IsClient {
+			s.rtt = time.Since(time.Unix(0, ping.Time))
+		}
+		return
+	}
+	ping.Pong = true
+	s.sendPing(ping)
+}
+
+func (s *Session) sendPing(ping *Ping) {
+	et, data, err := encodeMsg(ping)
+	if err == nil {
+		err = s.sendRaw(&MsgHead{MsgID: s.SC.PingMsgID}, et, data)
+	}
+	if err != nil {
+		log.WithField("SessionInfo", s).Warningf("send ping error: %v", err)
+	}
+}
+
+// keepalive 客户端定时发送心跳，关闭长时间没有收到消息的连接，在module节点上执行
+func (s *Session) keepalive(now time.Time) {
+	if s.owner.Load() != nil {
+		return
+	}
+	select {
+	case <-s.closeSign:
+		return
+	default:
+	}
+	s.ackResume(now)
+	if s.SC.IdleTimeout > 0 && now.Sub(s.lastRecv) > s.SC.IdleTimeout {
+		log.WithField("SessionInfo", s).Warningf("close conn: idle timeout %v", s.SC.IdleTimeout)
+		_ = s.CloseWithReason(CloseIdle)
+		return
+	}
+	if s.authTimeout(now) {
+		log.WithField("SessionInfo", s).Warningf("close conn: auth timeout %v", s.SC.Auth.Timeout)
+		_ = s.CloseWithReason(CloseAuthTimeout)
+		return
+	}
+	if s.SC.IsClient && s.SC.PingMsgID != 0 && now.Sub(s.lastPing) >= s.SC.PingInterval {
+		s.lastPing = now
+		s.sendPing(&Ping{Time: now.UnixNano()})
+	}
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatIdle(t *testing.T) {
+	testMsgHandler(t)
+	server, client := testConfigs(t)
+	p := newPipeTest(t, server, client)
+	// 配置的单位是秒，初始化之后再改成毫秒级
+	server.IdleTimeout = 50 * time.Millisecond
+	ss, _ := p.connected()
+	start := time.Now()
+
+	// 客户端不发送心跳，服务端超时后关闭连接
+	p.wait("idle closed", func() bool { return len(p.server.sessions) == 0 })
+	if elapsed := time.Since(start); elapsed < server.IdleTimeout {
+		t.Fatalf("closed after %v, idle timeout %v", elapsed, server.IdleTimeout)
+	}
+	if ss.CloseReason() != CloseIdle {
+		t.Fatalf("close reason %v", ss.CloseReason())
+	}
+}
+
+func TestHeartbeatKeepalive(t *testing.T) {
+	testMsgHandler(t)
+	server, client := testConfigs(t)
+	server.PingMsgID, client.PingMsgID = 100, 100
+	p := newPipeTest(t, server, client)
+	server.IdleTimeout = 50 * time.Millisecond
+	client.PingInterval = 5 * time.Millisecond
+	ss, cs := p.connected()
+
+	// 心跳保持连接，服务端自动返回，客户端测量往返时间
+	deadline := time.Now().Add(5 * server.IdleTimeout)
+	p.wait("keepalive", func() bool { return time.Now().After(deadline) })
+	if len(p.server.sessions) != 1 || len(p.client.sessions) != 1 {
+		t.Fatalf("closed with %v", ss.CloseReason())
+	}
+	if cs.RTT() <= 0 {
+		t.Fatalf("rtt %v", cs.RTT())
+	}
+	if ss.RTT() != 0 {
+		t.Fatalf("server measured rtt %v", ss.RTT())
+	}
+	if since := time.Since(cs.LastActive()); since > server.IdleTimeout {
+		t.Fatalf("client last active %v ago", since)
+	}
+}
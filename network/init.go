@@ -23,8 +23,12 @@ type Configuration struct {
 	MinMsgLen uint32
 	// MaxMsgLen 封包时应用层数据最大字节数
 	MaxMsgLen uint32
+	// MsgIDLen 消息号占用的字节数，2或4，默认2
+	MsgIDLen int
 	// Services 网络服务配置
 	Services []*ServiceConfig
+	// Discovery 服务发现配置
+	Discovery DiscoveryConfig
 }
 
 func (c *Configuration) Name() string {
@@ -32,12 +36,6 @@ func (c *Configuration) Name() string {
 }
 
 func (c *Configuration) Init() error {
-	for i := 0; i < len(c.Services); i++ {
-		if err := c.Services[i].init(); err != nil {
-			return err
-		}
-	}
-
 	if c.Endian {
 		encoding.SetByteOrder(binary.BigEndian)
 		gMsgParser.SetByteOrder(binary.BigEndian)
@@ -49,6 +47,24 @@ func (c *Configuration) Init() error {
 	}
 
 	c.LenMsgLen, c.MinMsgLen, c.MaxMsgLen = gPkgParser.SetMsgLen(c.LenMsgLen, c.MinMsgLen, c.MaxMsgLen)
+	c.MsgIDLen = gMsgParser.SetMsgIDLen(c.MsgIDLen)
+
+	for i := 0; i < len(c.Services); i++ {
+		if err := c.Services[i].init(); err != nil {
+			return err
+		}
+	}
+
+	// 服务发现
+	if c.Discovery.Registry != "" {
+		if err := c.Discovery.init(); err != nil {
+			return err
+		}
+		var err error
+		if gNetwork.discovery, err = NewDiscovery(gNetwork, &c.Discovery); err != nil {
+			return err
+		}
+	}
 
 	// 启动网络服务
 	module.Register(gNetwork, time.Millisecond*100, math.MaxInt32)
@@ -0,0 +1,834 @@
+package network
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 基于UDP的可靠传输，参考KCP的ARQ实现，提供 net.Listener 和 net.Conn，由 TCPServer 和 TCPClient 复用
+//
+// 数据报结构
+// -------------------------------
+// | conv | cmd | sn | una | wnd | data |
+// -------------------------------
+// conv 连接标识，4个字节，由客户端随机生成
+// cmd 命令，1个字节
+// sn 数据序号，4个字节
+// una 对端还没有收到的第一个数据序号，之前的数据都已经确认，4个字节
+// wnd 接收窗口剩余大小，2个字节
+// data cmd为 kcpCmdPush 时是数据，为 kcpCmdAck 时是确认的序号列表，为 kcpCmdSyn 和 kcpCmdCookie 时是 cookie
+//
+// 握手
+// 客户端发送不带 cookie 的 kcpCmdSyn，服务端不保存状态，根据客户端地址和 conv 生成 cookie 通过 kcpCmdCookie 返回，
+// 客户端携带 cookie 再次发送 kcpCmdSyn，服务端校验 cookie 后建立连接并返回 kcpCmdSynAck，
+// 伪造源地址的数据报收不到 cookie，不能让服务端创建连接；没有完成握手的其它数据报直接丢弃，不影响同一个地址上已经建立的连接
+
+const (
+	kcpCmdPush   uint8 = iota + 1 // 数据
+	kcpCmdAck                     // 确认
+	kcpCmdPing                    // 保活
+	kcpCmdFin                     // 关闭
+	kcpCmdSyn                     // 请求建立连接
+	kcpCmdCookie                  // 服务端返回的握手 cookie
+	kcpCmdSynAck                  // 连接已经建立
+)
+
+const (
+	kcpHeadLen       = 15
+	kcpMTU           = 1400                  // 默认的数据报最大字节数
+	kcpWindow        = 128                   // 默认的收发窗口大小
+	kcpInterval      = 10 * time.Millisecond // 默认的状态更新间隔
+	kcpRTOMin        = 30 * time.Millisecond // 最小超时重传时间
+	kcpRTOMax        = 5 * time.Second       // 最大超时重传时间
+	kcpFastResend    = 2                     // 跳过几次确认后快速重传
+	kcpDeadLink      = 20                    // 同一个数据重传多少次后认为连接断开
+	kcpPingInterval  = 5 * time.Second       // 没有发送数据时发送保活的时间间隔
+	kcpDeadTime      = 30 * time.Second      // 多久没有收到数据认为连接断开
+	kcpCloseTimeout  = 3 * time.Second       // 关闭连接时等待数据发送完成的最长时间
+	kcpMaxPacket     = 64 * 1024             // 读取数据报的缓冲区大小
+	kcpAcceptBacklog = 1000
+	kcpSynInterval   = 200 * time.Millisecond // 握手数据报重发间隔
+	kcpCookieLen     = 16
+	kcpCookieTTL     = 30 * time.Second // cookie 有效期，超过一个周期后失效
+)
+
+var (
+	errKCPDeadLink  = errors.New("kcp: dead link")
+	errKCPHandshake = errors.New("kcp: handshake timeout")
+	errKCPRefused   = errors.New("kcp: connection refused")
+	errKCPTimeout   = &kcpTimeoutError{}
+)
+
+type kcpTimeoutError struct{}
+
+func (e *kcpTimeoutError) Error() string   { return "kcp: i/o timeout" }
+func (e *kcpTimeoutError) Timeout() bool   { return true }
+func (e *kcpTimeoutError) Temporary() bool { return true }
+
+// kcpOption 可靠传输参数
+type kcpOption struct {
+	mtu      int
+	window   int
+	interval time.Duration
+	loss     float64       // 模拟丢包率，0到1，用于测试
+	timeout  time.Duration // 握手超时时间
+}
+
+func newKCPOption(sc *ServiceConfig) *kcpOption {
+	opt := &kcpOption{
+		mtu:      sc.MTU,
+		window:   sc.KCPWindow,
+		interval: time.Duration(sc.KCPInterval) * time.Millisecond,
+		timeout:  sc.HandshakeTimeout,
+	}
+	if opt.mtu <= kcpHeadLen || opt.mtu > kcpMaxPacket {
+		opt.mtu = kcpMTU
+	}
+	if opt.window <= 0 {
+		opt.window = kcpWindow
+	}
+	if opt.interval <= 0 {
+		opt.interval = kcpInterval
+	}
+	if opt.timeout <= 0 {
+		opt.timeout = defaultHandshakeTimeout
+	}
+	return opt
+}
+
+type kcpSegment struct {
+	sn       uint32
+	data     []byte
+	xmit     int       // 发送次数
+	sendAt   time.Time // 第一次发送时间
+	resendAt time.Time // 超时重传时间
+	fastAck  int       // 被跳过确认的次数
+}
+
+// kcpConn 可靠传输连接
+type kcpConn struct {
+	conv   uint32
+	opt    *kcpOption
+	local  net.Addr
+	remote net.Addr
+	output func(b []byte) error // 发送数据报
+	onEnd  func()               // 连接结束后的清理工作
+
+	mu       sync.Mutex
+	sndNxt   uint32 // 下一个数据序号
+	sndQueue [][]byte
+	sndBuf   []*kcpSegment // 已发送还没有确认的数据
+	rmtWnd   int
+	rcvNxt   uint32
+	rcvBuf   map[uint32][]byte // 乱序收到的数据
+	rcvData  []byte            // 按顺序排列还没有读取的数据
+	acks     []uint32
+	srtt     time.Duration
+	rttVar   time.Duration
+	rto      time.Duration
+	lastRecv time.Time
+	lastSend time.Time
+	readDL   time.Time
+	writeDL  time.Time
+	closing  bool // 本地已经关闭，等待数据发送完成
+	err      error
+
+	readSign  chan struct{}
+	writeSign chan struct{}
+	die       chan struct{}
+	dieOnce   sync.Once
+}
+
+func newKCPConn(conv uint32, opt *kcpOption, local, remote net.Addr, output func(b []byte) error) *kcpConn {
+	now := time.Now()
+	c := &kcpConn{
+		conv:      conv,
+		opt:       opt,
+		local:     local,
+		remote:    remote,
+		output:    output,
+		rmtWnd:    opt.window,
+		rcvBuf:    make(map[uint32][]byte),
+		rto:       200 * time.Millisecond,
+		lastRecv:  now,
+		lastSend:  now,
+		readSign:  make(chan struct{}, 1),
+		writeSign: make(chan struct{}, 1),
+		die:       make(chan struct{}),
+	}
+	go c.update()
+	return c
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait 等待通知，超时返回false
+func wait(sign, die chan struct{}, deadline time.Time) bool {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return false
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-sign:
+	case <-die:
+	case <-timeout:
+		return false
+	}
+	return true
+}
+
+func (c *kcpConn) mss() int {
+	return c.opt.mtu - kcpHeadLen
+}
+
+// kcpPacket 数据报序列化
+func kcpPacket(conv uint32, cmd uint8, sn, una uint32, wnd int, data []byte) []byte {
+	buf := make([]byte, kcpHeadLen+len(data))
+	binary.LittleEndian.PutUint32(buf, conv)
+	buf[4] = cmd
+	binary.LittleEndian.PutUint32(buf[5:], sn)
+	binary.LittleEndian.PutUint32(buf[9:], una)
+	binary.LittleEndian.PutUint16(buf[13:], uint16(wnd))
+	copy(buf[kcpHeadLen:], data)
+	return buf
+}
+
+// send 发送数据报，调用时需要加锁
+func (c *kcpConn) send(cmd uint8, sn uint32, data []byte) {
+	buf := kcpPacket(c.conv, cmd, sn, c.rcvNxt, c.rcvWnd(), data)
+	c.lastSend = time.Now()
+	if c.opt.loss > 0 && rand.Float64() < c.opt.loss {
+		return
+	}
+	_ = c.output(buf)
+}
+
+// rcvWnd 接收窗口剩余大小
+func (c *kcpConn) rcvWnd() int {
+	n := c.opt.window - len(c.rcvBuf) - len(c.rcvData)/c.mss()
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// input 处理收到的数据报
+func (c *kcpConn) input(b []byte) {
+	if len(b) < kcpHeadLen {
+		return
+	}
+	cmd := b[4]
+	if cmd >= kcpCmdSyn {
+		// 重复到达的握手数据报
+		return
+	}
+	sn := binary.LittleEndian.Uint32(b[5:])
+	una := binary.LittleEndian.Uint32(b[9:])
+	wnd := int(binary.LittleEndian.Uint16(b[13:]))
+	data := b[kcpHeadLen:]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	now := time.Now()
+	c.lastRecv = now
+	c.rmtWnd = wnd
+
+	// una 之前的数据都已经确认
+	n := 0
+	for n < len(c.sndBuf) && int32(c.sndBuf[n].sn-una) < 0 {
+		n++
+	}
+	c.sndBuf = c.sndBuf[n:]
+
+	switch cmd {
+	case kcpCmdPush:
+		if int32(sn-c.rcvNxt) >= int32(c.opt.window) {
+			return
+		}
+		c.acks = append(c.acks, sn)
+		if int32(sn-c.rcvNxt) < 0 {
+			return
+		}
+		if _, ok := c.rcvBuf[sn]; !ok {
+			c.rcvBuf[sn] = append([]byte(nil), data...)
+		}
+		for {
+			v, ok := c.rcvBuf[c.rcvNxt]
+			if !ok {
+				break
+			}
+			delete(c.rcvBuf, c.rcvNxt)
+			c.rcvData = append(c.rcvData, v...)
+			c.rcvNxt++
+		}
+		notify(c.readSign)
+
+	case kcpCmdAck:
+		for len(data) >= 4 {
+			c.ack(binary.LittleEndian.Uint32(data), now)
+			data = data[4:]
+		}
+
+	case kcpCmdFin:
+		c.err = io.EOF
+		notify(c.readSign)
+		c.end()
+		return
+	}
+
+	if n > 0 || cmd == kcpCmdAck {
+		c.flush(now)
+	}
+}
+
+// ack 确认数据，调用时需要加锁
+func (c *kcpConn) ack(sn uint32, now time.Time) {
+	for i, v := range c.sndBuf {
+		if v.sn == sn {
+			if v.xmit == 1 {
+				c.updateRTT(now.Sub(v.sendAt))
+			}
+			c.sndBuf = append(c.sndBuf[:i], c.sndBuf[i+1:]...)
+			return
+		}
+		if int32(v.sn-sn) > 0 {
+			return
+		}
+		v.fastAck++
+	}
+}
+
+func (c *kcpConn) updateRTT(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttVar = rtt / 2
+	} else {
+		delta := rtt - c.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar = (3*c.rttVar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttVar
+	if c.rto < kcpRTOMin {
+		c.rto = kcpRTOMin
+	} else if c.rto > kcpRTOMax {
+		c.rto = kcpRTOMax
+	}
+}
+
+// flush 发送确认，新数据和需要重传的数据，调用时需要加锁
+func (c *kcpConn) flush(now time.Time) {
+	// 确认
+	for len(c.acks) > 0 {
+		n := len(c.acks)
+		if max := c.mss() / 4; n > max {
+			n = max
+		}
+		data := make([]byte, n*4)
+		for i := 0; i < n; i++ {
+			binary.LittleEndian.PutUint32(data[i*4:], c.acks[i])
+		}
+		c.acks = c.acks[n:]
+		c.send(kcpCmdAck, 0, data)
+	}
+	c.acks = nil
+
+	// 新数据，对端窗口为0时也发送一个数据用来探测窗口
+	wnd := c.opt.window
+	if c.rmtWnd < wnd {
+		wnd = c.rmtWnd
+	}
+	if wnd == 0 {
+		wnd = 1
+	}
+	sent := false
+	for len(c.sndQueue) > 0 && len(c.sndBuf) < wnd {
+		seg := &kcpSegment{
+			sn:   c.sndNxt,
+			data: c.sndQueue[0],
+		}
+		c.sndNxt++
+		c.sndQueue = c.sndQueue[1:]
+		c.sndBuf = append(c.sndBuf, seg)
+		sent = true
+	}
+	if sent {
+		notify(c.writeSign)
+	}
+
+	for _, v := range c.sndBuf {
+		switch {
+		case v.xmit == 0:
+			v.sendAt = now
+		case v.fastAck >= kcpFastResend:
+			v.fastAck = 0
+		case !now.Before(v.resendAt):
+			if v.xmit >= kcpDeadLink {
+				c.err = errKCPDeadLink
+				notify(c.readSign)
+				c.end()
+				return
+			}
+		default:
+			continue
+		}
+		rto := c.rto
+		if v.xmit > 0 {
+			// 重传退避
+			rto += c.rto * time.Duration(v.xmit) / 2
+		}
+		if rto > kcpRTOMax {
+			rto = kcpRTOMax
+		}
+		v.xmit++
+		v.resendAt = now.Add(rto)
+		c.send(kcpCmdPush, v.sn, v.data)
+	}
+}
+
+// update 定时更新状态
+func (c *kcpConn) update() {
+	ticker := time.NewTicker(c.opt.interval)
+	defer ticker.Stop()
+	var closeAt time.Time
+	for {
+		select {
+		case <-c.die:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			if c.err == nil && now.Sub(c.lastRecv) > kcpDeadTime {
+				c.err = errKCPDeadLink
+				notify(c.readSign)
+				c.end()
+			}
+			if c.err != nil {
+				c.mu.Unlock()
+				return
+			}
+			c.flush(now)
+			if c.err == nil && now.Sub(c.lastSend) > kcpPingInterval {
+				c.send(kcpCmdPing, 0, nil)
+			}
+			if c.closing {
+				if closeAt.IsZero() {
+					closeAt = now
+				}
+				if (len(c.sndBuf) == 0 && len(c.sndQueue) == 0) || now.Sub(closeAt) > kcpCloseTimeout {
+					c.send(kcpCmdFin, 0, nil)
+					c.err = net.ErrClosed
+					c.end()
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// end 结束连接，调用时需要加锁
+func (c *kcpConn) end() {
+	c.dieOnce.Do(func() {
+		close(c.die)
+		if c.onEnd != nil {
+			go c.onEnd()
+		}
+	})
+}
+
+func (c *kcpConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.rcvData) > 0 {
+			n := copy(b, c.rcvData)
+			c.rcvData = c.rcvData[n:]
+			if len(c.rcvData) == 0 {
+				c.rcvData = nil
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.closing {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		dl := c.readDL
+		c.mu.Unlock()
+
+		if !wait(c.readSign, c.die, dl) {
+			return 0, errKCPTimeout
+		}
+	}
+}
+
+func (c *kcpConn) Write(b []byte) (int, error) {
+	n := 0
+	for {
+		c.mu.Lock()
+		if c.closing {
+			c.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return n, err
+		}
+		// 发送队列满时等待
+		mss := c.mss()
+		for len(b) > 0 && len(c.sndQueue) < c.opt.window*2 {
+			l := len(b)
+			if l > mss {
+				l = mss
+			}
+			c.sndQueue = append(c.sndQueue, append([]byte(nil), b[:l]...))
+			b = b[l:]
+			n += l
+		}
+		c.flush(time.Now())
+		dl := c.writeDL
+		c.mu.Unlock()
+		if len(b) == 0 {
+			return n, nil
+		}
+
+		if !wait(c.writeSign, c.die, dl) {
+			return n, errKCPTimeout
+		}
+	}
+}
+
+// Close 关闭连接，已经写入的数据在后台继续发送，发送完成或超时后通知对端
+func (c *kcpConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing || c.err != nil {
+		return nil
+	}
+	c.closing = true
+	notify(c.readSign)
+	notify(c.writeSign)
+	return nil
+}
+
+func (c *kcpConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *kcpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *kcpConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDL, c.writeDL = t, t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *kcpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDL = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *kcpConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDL = t
+	c.mu.Unlock()
+	return nil
+}
+
+// kcpKey 服务端连接标识，同一个地址可以同时存在多个连接
+type kcpKey struct {
+	addr string
+	conv uint32
+}
+
+// kcpListener 可靠传输服务端，所有连接共用一个UDP端口
+type kcpListener struct {
+	pc       net.PacketConn
+	opt      *kcpOption
+	secret   []byte // 生成握手 cookie 的秘钥
+	mu       sync.Mutex
+	conns    map[kcpKey]*kcpConn
+	ended    map[kcpKey]time.Time // 已经结束的连接，对端继续发送数据时通知对端关闭
+	acceptCh chan *kcpConn
+	die      chan struct{}
+	dieOnce  sync.Once
+}
+
+func listenKCP(addr string, opt *kcpOption) (*kcpListener, error) {
+	secret := make([]byte, 32)
+	if _, err := crand.Read(secret); err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &kcpListener{
+		pc:       pc,
+		opt:      opt,
+		secret:   secret,
+		conns:    make(map[kcpKey]*kcpConn),
+		ended:    make(map[kcpKey]time.Time),
+		acceptCh: make(chan *kcpConn, kcpAcceptBacklog),
+		die:      make(chan struct{}),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+func (l *kcpListener) readLoop() {
+	buf := make([]byte, kcpMaxPacket)
+	clean := time.Now()
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+		if n < kcpHeadLen {
+			continue
+		}
+		conv := binary.LittleEndian.Uint32(buf)
+		cmd := buf[4]
+		key := kcpKey{addr: addr.String(), conv: conv}
+
+		l.mu.Lock()
+		if now := time.Now(); now.Sub(clean) > kcpDeadTime {
+			clean = now
+			for k, v := range l.ended {
+				if now.Sub(v) > kcpDeadTime {
+					delete(l.ended, k)
+				}
+			}
+		}
+		c := l.conns[key]
+		_, ended := l.ended[key]
+		l.mu.Unlock()
+
+		switch {
+		case c != nil && cmd == kcpCmdSyn:
+			// 客户端没有收到握手确认
+			_, _ = l.pc.WriteTo(kcpPacket(conv, kcpCmdSynAck, 0, 0, 0, nil), addr)
+		case c != nil:
+			c.input(buf[:n])
+		case ended:
+			if cmd != kcpCmdFin {
+				_, _ = l.pc.WriteTo(kcpPacket(conv, kcpCmdFin, 0, 0, 0, nil), addr)
+			}
+		case cmd == kcpCmdSyn:
+			l.handshake(key, addr, buf[kcpHeadLen:n])
+		}
+		// 没有完成握手的其它数据报直接丢弃
+	}
+}
+
+// cookie 根据客户端地址，conv 和时间周期生成握手 cookie
+func (l *kcpListener) cookie(key kcpKey, period int64) []byte {
+	var b [12]byte
+	binary.LittleEndian.PutUint32(b[:], key.conv)
+	binary.LittleEndian.PutUint64(b[4:], uint64(period))
+	h := hmac.New(sha256.New, l.secret)
+	h.Write(b[:])
+	h.Write([]byte(key.addr))
+	return h.Sum(nil)[:kcpCookieLen]
+}
+
+// handshake 处理客户端的握手请求，cookie 校验通过后建立连接
+func (l *kcpListener) handshake(key kcpKey, addr net.Addr, cookie []byte) {
+	period := time.Now().Unix() / int64(kcpCookieTTL/time.Second)
+	if len(cookie) != kcpCookieLen ||
+		!hmac.Equal(cookie, l.cookie(key, period)) && !hmac.Equal(cookie, l.cookie(key, period-1)) {
+		_, _ = l.pc.WriteTo(kcpPacket(key.conv, kcpCmdCookie, 0, 0, 0, l.cookie(key, period)), addr)
+		return
+	}
+
+	c := newKCPConn(key.conv, l.opt, l.pc.LocalAddr(), addr, func(b []byte) error {
+		_, err := l.pc.WriteTo(b, addr)
+		return err
+	})
+	c.onEnd = func() {
+		l.mu.Lock()
+		if l.conns[key] == c {
+			delete(l.conns, key)
+			l.ended[key] = time.Now()
+		}
+		l.mu.Unlock()
+	}
+	l.mu.Lock()
+	select {
+	case l.acceptCh <- c:
+		l.conns[key] = c
+		l.mu.Unlock()
+	default:
+		// 等待接受的连接太多，客户端会重新发送握手请求
+		l.mu.Unlock()
+		c.mu.Lock()
+		c.err = net.ErrClosed
+		c.end()
+		c.mu.Unlock()
+		return
+	}
+	_, _ = l.pc.WriteTo(kcpPacket(key.conv, kcpCmdSynAck, 0, 0, 0, nil), addr)
+}
+
+func (l *kcpListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.acceptCh:
+		return c, nil
+	case <-l.die:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听，已经建立的连接同时关闭
+func (l *kcpListener) Close() error {
+	l.dieOnce.Do(func() {
+		close(l.die)
+		l.pc.Close()
+		l.mu.Lock()
+		for _, c := range l.conns {
+			c.mu.Lock()
+			c.err = net.ErrClosed
+			notify(c.readSign)
+			c.end()
+			c.mu.Unlock()
+		}
+		l.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *kcpListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// dialKCP 建立可靠传输连接
+func dialKCP(addr string, opt *kcpOption) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	conv := rand.Uint32()
+	first, err := kcpHandshake(udp, conv, opt.timeout)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	c := newKCPConn(conv, opt, udp.LocalAddr(), raddr, func(b []byte) error {
+		_, err := udp.Write(b)
+		return err
+	})
+	c.onEnd = func() {
+		udp.Close()
+	}
+
+	go func() {
+		if first != nil {
+			c.input(first)
+		}
+		buf := make([]byte, kcpMaxPacket)
+		for {
+			n, err := udp.Read(buf)
+			if err != nil {
+				c.mu.Lock()
+				if c.err == nil {
+					c.err = err
+					notify(c.readSign)
+					c.end()
+				}
+				c.mu.Unlock()
+				return
+			}
+			if n >= kcpHeadLen && binary.LittleEndian.Uint32(buf) == c.conv {
+				c.input(buf[:n])
+			}
+		}
+	}()
+	return c, nil
+}
+
+// kcpHandshake 客户端握手，超时前每隔 kcpSynInterval 重新发送握手请求
+// 返回握手期间收到的服务端数据报，服务端的数据可能比握手确认先到达
+func kcpHandshake(udp *net.UDPConn, conv uint32, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	defer udp.SetReadDeadline(time.Time{})
+
+	var cookie []byte
+	buf := make([]byte, kcpMaxPacket)
+	for time.Now().Before(deadline) {
+		if _, err := udp.Write(kcpPacket(conv, kcpCmdSyn, 0, 0, 0, cookie)); err != nil {
+			return nil, err
+		}
+		dl := time.Now().Add(kcpSynInterval)
+		if dl.After(deadline) {
+			dl = deadline
+		}
+		udp.SetReadDeadline(dl)
+	read:
+		for {
+			n, err := udp.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if n < kcpHeadLen || binary.LittleEndian.Uint32(buf) != conv {
+				continue
+			}
+			switch buf[4] {
+			case kcpCmdCookie:
+				cookie = append([]byte(nil), buf[kcpHeadLen:n]...)
+				break read
+			case kcpCmdSynAck:
+				return nil, nil
+			case kcpCmdFin:
+				return nil, errKCPRefused
+			default:
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+	return nil, errKCPHandshake
+}
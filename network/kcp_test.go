@@ -0,0 +1,139 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKCPLoss(t *testing.T) {
+	opt := &kcpOption{mtu: kcpMTU, window: kcpWindow, interval: kcpInterval, loss: 0.2}
+	ln, err := listenKCP("127.0.0.1:0", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// 原样返回
+		io.CopyN(conn, conn, int64(len(data)))
+	}()
+
+	conn, err := dialKCP(ln.Addr().String(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	if _, err = io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("data mismatch")
+	}
+}
+
+func TestKCPHandshake(t *testing.T) {
+	opt := &kcpOption{mtu: kcpMTU, window: kcpWindow, interval: kcpInterval}
+	ln, err := listenKCP("127.0.0.1:0", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	raddr, _ := net.ResolveUDPAddr("udp", ln.Addr().String())
+	udp, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	// 没有握手的数据和错误的 cookie 都不能建立连接
+	udp.Write(kcpPacket(1, kcpCmdPush, 0, 0, kcpWindow, []byte("data")))
+	udp.Write(kcpPacket(1, kcpCmdPing, 0, 0, kcpWindow, nil))
+	udp.Write(kcpPacket(1, kcpCmdSyn, 0, 0, 0, make([]byte, kcpCookieLen)))
+	udp.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, kcpMaxPacket)
+	n, err := udp.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != kcpHeadLen+kcpCookieLen || buf[4] != kcpCmdCookie {
+		t.Fatalf("cookie expected, cmd %d len %d", buf[4], n)
+	}
+	ln.mu.Lock()
+	conns := len(ln.conns)
+	ln.mu.Unlock()
+	if conns != 0 || len(ln.acceptCh) != 0 {
+		t.Fatal("connection created without handshake")
+	}
+
+	// 携带 cookie 完成握手
+	udp.Write(kcpPacket(1, kcpCmdSyn, 0, 0, 0, buf[kcpHeadLen:n]))
+	if n, err = udp.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[4] != kcpCmdSynAck || len(ln.acceptCh) != 1 {
+		t.Fatalf("handshake failed, cmd %d", buf[4])
+	}
+}
+
+func TestKCPConvConflict(t *testing.T) {
+	opt := &kcpOption{mtu: kcpMTU, window: kcpWindow, interval: kcpInterval}
+	ln, err := listenKCP("127.0.0.1:0", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := dialKCP(ln.Addr().String(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	echo := func(s string) {
+		if _, err := conn.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(s))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("echo: %q", got)
+		}
+	}
+	echo("hello")
+
+	// 同一个地址发送其它 conv 的数据报，不影响已经建立的连接
+	c := conn.(*kcpConn)
+	other := c.conv + 1
+	c.output(kcpPacket(other, kcpCmdPush, 0, 0, kcpWindow, []byte("data")))
+	c.output(kcpPacket(other, kcpCmdFin, 0, 0, 0, nil))
+	c.output(kcpPacket(other, kcpCmdSyn, 0, 0, 0, nil))
+	time.Sleep(50 * time.Millisecond)
+	echo("world")
+}
@@ -0,0 +1,140 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// envListenFds 环境变量，记录从父进程继承的监听端口，格式 "服务标识=文件描述符,..."
+const envListenFds = "CUBE_LISTEN_FDS"
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   map[ServerKey]*os.File
+)
+
+// inheritedFile 获取从父进程继承的监听端口，每个端口只能获取一次
+func inheritedFile(key ServerKey) *os.File {
+	inheritOnce.Do(func() {
+		inherited = make(map[ServerKey]*os.File)
+		for _, v := range strings.Split(os.Getenv(envListenFds), ",") {
+			kv := strings.SplitN(v, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			k, err1 := strconv.ParseUint(kv[0], 10, 32)
+			fd, err2 := strconv.Atoi(kv[1])
+			if err1 != nil || err2 != nil {
+				log.Warningf("invalid %s: %s", envListenFds, v)
+				continue
+			}
+			inherited[ServerKey(k)] = os.NewFile(uintptr(fd), "listener_"+kv[0])
+		}
+	})
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	f := inherited[key]
+	delete(inherited, key)
+	return f
+}
+
+// listen 服务端监听，优先使用从父进程继承的监听端口
+// network "tcp" "unix"
+func listen(config *ServiceConfig, network, addr string) (net.Listener, error) {
+	if f := inheritedFile(config.Key()); f != nil {
+		defer f.Close()
+		ln, err := net.FileListener(f)
+		if err == nil {
+			log.WithField("ServiceInfo", config).Trace("listener inherited")
+			return ln, nil
+		}
+		log.WithField("ServiceInfo", config).Warningf("inherit listener error: %v", err)
+	}
+	if network == "unix" {
+		return listenUnix(addr)
+	}
+	if config.ReusePort {
+		return listenReusePort(network, addr)
+	}
+	return net.Listen(network, addr)
+}
+
+// Upgrade 启动新进程并把监听端口交给新进程，新进程使用相同的启动参数，用于不断开服务的重启
+// 新进程启动后当前进程应该调用 module.Close 关闭，配置了 ServiceConfig.DrainTimeout 的服务会优雅关闭
+// 只支持 tcp ws wss unix 协议的服务端，不支持 windows
+// 线程不安全，必须在module节点上执行
+func (n *Network) Upgrade() (*os.Process, error) {
+	var files []*os.File
+	var fds []string
+	var unix []*net.UnixListener
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for k, s := range n.service {
+		v, ok := s.(interface{ listener() net.Listener })
+		if !ok || v.listener() == nil {
+			continue
+		}
+		ln, ok := v.listener().(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		if u, ok := ln.(*net.UnixListener); ok {
+			unix = append(unix, u)
+		}
+		f, err := ln.File()
+		if err != nil {
+			return nil, err
+		}
+		// 子进程中 ExtraFiles 的文件描述符从3开始
+		fds = append(fds, fmt.Sprintf("%d=%d", k, 3+len(files)))
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no listener to upgrade")
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, envListenFds+"=") {
+			cmd.Env = append(cmd.Env, v)
+		}
+	}
+	cmd.Env = append(cmd.Env, envListenFds+"="+strings.Join(fds, ","))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	// 新进程启动后，关闭时不能删除新进程正在使用的套接字文件
+	for _, u := range unix {
+		u.SetUnlinkOnClose(false)
+	}
+	return cmd.Process, nil
+}
+
+// Upgrade 启动新进程并把监听端口交给新进程，新进程使用相同的启动参数，用于不断开服务的重启
+// 新进程启动后当前进程应该调用 module.Close 关闭，配置了 ServiceConfig.DrainTimeout 的服务会优雅关闭
+// 线程不安全，必须在module节点上执行
+func Upgrade() (*os.Process, error) {
+	return gNetwork.Upgrade()
+}
@@ -0,0 +1,135 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	h := testMsgHandler(t)
+	var ss *Session
+	h.SetHandlerFunc(1, new(pipeMsg), func(c *Context) {
+		ss = c.Session
+		c.Send(2, &pipeMsg{Text: c.Msg.(*pipeMsg).Text})
+	})
+	var replies []string
+	h.SetHandlerFunc(2, new(pipeMsg), func(c *Context) {
+		replies = append(replies, c.Msg.(*pipeMsg).Text)
+	})
+	var goAway *GoAway
+	h.SetHandlerFunc(3, new(GoAway), func(c *Context) {
+		goAway = c.Msg.(*GoAway)
+	})
+
+	server := &ServiceConfig{
+		ServerInfo:   ServerInfo{Area: 1, Type: 1, ID: 1},
+		Protocol:     "pipe",
+		Path:         t.Name(),
+		Handler:      t.Name(),
+		DrainTimeout: 1,
+		GoAwayMsgID:  3,
+	}
+	client := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		Handler:    t.Name(),
+		IsClient:   true,
+		ClientNum:  1,
+	}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.init(); err != nil {
+		t.Fatal(err)
+	}
+	n1, n2 := NewNetwork(), NewNetwork()
+	if n1.newService(server) == nil {
+		t.Fatal("server start error")
+	}
+	c, ok := n2.newService(client).(*TCPClient)
+	if !ok {
+		t.Fatal("client start error")
+	}
+	defer c.Shutdown()
+
+	var cs *Session
+	var shutdown time.Time
+	sent := false
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		n1.Update()
+		n2.Update()
+		switch {
+		case cs == nil:
+			for s := range c.sessions {
+				cs = s
+				cs.Send(1, &pipeMsg{Text: "before"})
+			}
+		case len(replies) == 1 && shutdown.IsZero():
+			shutdown = time.Now()
+			n1.service[server.Key()].Shutdown()
+		case goAway != nil && !sent:
+			// 优雅关闭期间继续正常收发消息
+			cs.Send(1, &pipeMsg{Text: "draining"})
+			sent = true
+		}
+		if cs != nil && cs.CloseReason() != CloseNone {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if goAway == nil || goAway.Timeout != 1 {
+		t.Fatalf("goaway: %v", goAway)
+	}
+	if len(replies) != 2 || replies[1] != "draining" {
+		t.Fatalf("replies: %v", replies)
+	}
+	if d := time.Since(shutdown); d < time.Second {
+		t.Errorf("closed before drain timeout: %v", d)
+	}
+	if ss.CloseReason() != CloseShutdown {
+		t.Errorf("close reason: %v", ss.CloseReason())
+	}
+}
+
+func TestInheritListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	config := &ServiceConfig{ServerInfo: ServerInfo{Area: 1, Type: 1, ID: 1}}
+	t.Setenv(envListenFds, fmt.Sprintf("%d=%d", config.Key(), f.Fd()))
+	inheritOnce = sync.Once{}
+	defer func() { inheritOnce = sync.Once{} }()
+
+	inherit, err := listen(config, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherit.Close()
+	if inherit.Addr().String() != ln.Addr().String() {
+		t.Fatalf("addr: %v, want %v", inherit.Addr(), ln.Addr())
+	}
+
+	// 每个端口只能继承一次
+	again, err := listen(config, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if again.Addr().String() == ln.Addr().String() {
+		t.Fatal("listener inherited twice")
+	}
+}
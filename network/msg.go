@@ -20,34 +20,55 @@ func (hw HandlerWrapper) Process(c *Context) {
 type MsgInfo struct {
 	msgType    reflect.Type
 	msgHandler Handler
+	newMsg     func() interface{}    // 创建消息，为nil时使用反射创建，见 SetHandle
+	freeMsg    func(msg interface{}) // 消息处理完成后放回对象池
 }
 
 // MsgHandler 消息注册表
 type MsgHandler struct {
-	messages map[uint16]*MsgInfo
+	messages map[uint32]*MsgInfo
 }
 
 func NewMsgHandler() *MsgHandler {
 	return &MsgHandler{
-		messages: make(map[uint16]*MsgInfo),
+		messages: make(map[uint32]*MsgInfo),
 	}
 }
 
 // CreateMessage 根据消息号创建对应的消息实例
 // msgID 消息号
 // 返回消息结构体的指针
-func (m *MsgHandler) CreateMessage(msgID uint16) interface{} {
+func (m *MsgHandler) CreateMessage(msgID uint32) interface{} {
 	v, ok := m.messages[msgID]
 	if !ok || v.msgType == nil {
 		return nil
 	}
+	if v.newMsg != nil {
+		return v.newMsg()
+	}
 	return reflect.New(v.msgType.Elem()).Interface()
 }
 
+// registered 消息号是否已经注册消息结构
+func (m *MsgHandler) registered(msgID uint32) bool {
+	v, ok := m.messages[msgID]
+	return ok && v.msgType != nil
+}
+
+// freeMessage 消息处理完成后放回对象池，没有使用对象池时不处理
+func (m *MsgHandler) freeMessage(msgID uint32, msg interface{}) bool {
+	v, ok := m.messages[msgID]
+	if !ok || v.freeMsg == nil {
+		return false
+	}
+	v.freeMsg(msg)
+	return true
+}
+
 // GetHandler 根据消息号获取消息处理方法
 // msgID 消息号
 // handler 消息处理方法
-func (m *MsgHandler) GetHandler(msgID uint16) Handler {
+func (m *MsgHandler) GetHandler(msgID uint32) Handler {
 	v, ok := m.messages[msgID]
 	if !ok {
 		return nil
@@ -59,7 +80,7 @@ func (m *MsgHandler) GetHandler(msgID uint16) Handler {
 // msgID 消息号
 // msg 消息结构体指针
 // handler 消息处理方法
-func (m *MsgHandler) SetHandler(msgID uint16, msg interface{}, handler Handler) {
+func (m *MsgHandler) SetHandler(msgID uint32, msg interface{}, handler Handler) {
 	if _, ok := m.messages[msgID]; ok {
 		log.WithField("msgID", msgID).Panicln("message already exist")
 		return
@@ -82,27 +103,62 @@ func (m *MsgHandler) SetHandler(msgID uint16, msg interface{}, handler Handler)
 	}
 }
 
+// SetMessage 注册消息类型，用于不需要消息处理方法的消息，例如rpc的返回消息
+// msgID 消息号
+// msg 消息结构体指针
+func (m *MsgHandler) SetMessage(msgID uint32, msg interface{}) {
+	if _, ok := m.messages[msgID]; ok {
+		log.WithField("msgID", msgID).Panicln("message already exist")
+		return
+	}
+
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.WithField("msgID", msgID).Panicln("message pointer required")
+		return
+	}
+
+	m.messages[msgID] = &MsgInfo{
+		msgType: msgType,
+	}
+}
+
 // SetHandlerFunc 设置消息处理方法
 // msgID 消息号
 // msg 消息结构体指针
 // handlerFunc 消息处理方法
-func (m *MsgHandler) SetHandlerFunc(msgID uint16, msg interface{}, handlerFunc func(c *Context)) {
+func (m *MsgHandler) SetHandlerFunc(msgID uint32, msg interface{}, handlerFunc func(c *Context)) {
 	m.SetHandler(msgID, msg, HandlerWrapper(handlerFunc))
 }
 
 var gMsgHandler = NewMsgHandler()
 
+// gMsgHandlers 所有消息注册表，名称为空的是默认注册表
+var gMsgHandlers = map[string]*MsgHandler{"": gMsgHandler}
+
+// GetMsgHandler 根据名称获取消息注册表，不存在时创建
+// 服务配置 ServiceConfig.Handler 指定服务使用的消息注册表，服务只能处理自己注册表中的消息
+// name 名称，为空时返回默认注册表，SetHandler 等方法都是在默认注册表中注册消息
+func GetMsgHandler(name string) *MsgHandler {
+	m, ok := gMsgHandlers[name]
+	if !ok {
+		m = NewMsgHandler()
+		gMsgHandlers[name] = m
+	}
+	return m
+}
+
 // CreateMessage 根据消息号创建对应的消息实例
 // msgID 消息号
 // 返回消息结构体的指针
-func CreateMessage(msgID uint16) interface{} {
+func CreateMessage(msgID uint32) interface{} {
 	return gMsgHandler.CreateMessage(msgID)
 }
 
 // GetHandler 根据消息号获取消息处理方法
 // msgID 消息号
 // handler 消息处理方法
-func GetHandler(msgID uint16) Handler {
+func GetHandler(msgID uint32) Handler {
 	return gMsgHandler.GetHandler(msgID)
 }
 
@@ -110,7 +166,7 @@ func GetHandler(msgID uint16) Handler {
 // msgID 消息号
 // msg 消息结构体指针
 // handler 消息处理方法
-func SetHandler(msgID uint16, msg interface{}, handler Handler) {
+func SetHandler(msgID uint32, msg interface{}, handler Handler) {
 	gMsgHandler.SetHandler(msgID, msg, handler)
 }
 
@@ -118,6 +174,13 @@ func SetHandler(msgID uint16, msg interface{}, handler Handler) {
 // msgID 消息号
 // msg 消息结构体指针
 // handlerFunc 消息处理方法
-func SetHandlerFunc(msgID uint16, msg interface{}, handlerFunc func(c *Context)) {
+func SetHandlerFunc(msgID uint32, msg interface{}, handlerFunc func(c *Context)) {
 	gMsgHandler.SetHandlerFunc(msgID, msg, handlerFunc)
 }
+
+// SetMessage 注册消息类型，用于不需要消息处理方法的消息，例如rpc的返回消息
+// msgID 消息号
+// msg 消息结构体指针
+func SetMessage(msgID uint32, msg interface{}) {
+	gMsgHandler.SetMessage(msgID, msg)
+}
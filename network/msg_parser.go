@@ -3,6 +3,8 @@ package network
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"math"
 
 	"github.com/skeletongo/cube/encoding"
 )
@@ -10,52 +12,270 @@ import (
 // 应用层消息解析器
 //
 // 应用层消息序列化结构
-// -----------------------
-// |EncodeType|MsgID|Data|
-// -----------------------
-// EncodeType 编解码类型
-// MsgID 消息号
-// Data 消息数据
+// ---------------------------------
+// |EncodeType|MsgID|[Seq]|[Client]|[UID]|[Fragment]|Data|
+// ---------------------------------
+// EncodeType 编解码类型，占用2个字节，低8位是编解码类型，高8位是标记位，标记位为0时与旧版本的消息结构相同
+// MsgID 消息号，占用2或4个字节，默认2个字节，见 SetMsgIDLen
+// Seq rpc序号，标记位包含 FlagRequest 或 FlagResponse 时才有
+// Client 网关转发消息的客户端连接标识，标记位包含 FlagForward 时才有
+// UID 网关转发消息的客户端用户ID，占用8个字节，标记位包含 FlagUser 时才有
+// Fragment 分片序号和分片总数，各占用2个字节，标记位包含 FlagFragment 时才有
+// Data 消息数据，标记位包含 FlagCompressed 时第一个字节是压缩算法编号，后面是压缩后的数据
+
+// 消息头标记位
+const (
+	FlagRequest    uint8 = 1 << iota // rpc请求
+	FlagResponse                     // rpc返回
+	FlagForward                      // 网关转发
+	FlagCompressed                   // 消息数据已压缩，解析时自动解压
+	FlagFragment                     // 分片消息，接收方重组后再处理
+	FlagUser                         // 网关转发消息携带客户端用户ID，见 BindUser
+)
+
+const (
+	DefaultCompressThreshold = 1024    // 默认的压缩阈值，单位字节
+	DefaultMaxDecompressLen  = 4 << 20 // 默认的解压后消息数据最大字节数
+)
+
+// MsgHead 消息头
+type MsgHead struct {
+	Flags  uint8      // 标记位
+	MsgID  uint32     // 消息号
+	Seq    uint32     // rpc序号
+	Client SessionKey // 网关转发消息的客户端连接标识
+	UID    uint64     // 网关转发消息的客户端用户ID
+
+	FragIndex uint16 // 分片序号，从0开始
+	FragTotal uint16 // 分片总数
+}
+
+// HasFlag 是否包含标记位
+func (h *MsgHead) HasFlag(flag uint8) bool {
+	return h.Flags&flag != 0
+}
 
 // MsgParser 消息序列化和反序列化
 type MsgParser struct {
-	endian binary.ByteOrder
+	endian            binary.ByteOrder
+	msgIDLen          int             // 消息号占用的字节数
+	compress          *compressorInfo // 压缩算法，为nil时不压缩
+	compressThreshold int             // 消息数据超过这个字节数时压缩
+	maxDecompressLen  int             // 解压后消息数据最大字节数
 }
 
 func NewMsgParser() *MsgParser {
 	return &MsgParser{
-		endian: binary.LittleEndian,
+		endian:           binary.LittleEndian,
+		msgIDLen:         2,
+		maxDecompressLen: DefaultMaxDecompressLen,
 	}
 }
 
+// clone 复制解析器配置
+func (m *MsgParser) clone() *MsgParser {
+	c := *m
+	return &c
+}
+
 // SetByteOrder 修改字节序，默认小端序
 func (m *MsgParser) SetByteOrder(order binary.ByteOrder) {
 	m.endian = order
 }
 
+// SetMsgIDLen 修改消息号占用的字节数，默认2个字节
+// n 2或4，其它值不做修改
+// 返回校验后的配置
+func (m *MsgParser) SetMsgIDLen(n int) int {
+	if n == 2 || n == 4 {
+		m.msgIDLen = n
+	}
+	return m.msgIDLen
+}
+
+// SetCompress 设置压缩算法，默认不压缩
+// name 压缩算法名称，见 RegisterCompressor，为空时不压缩
+// threshold 消息数据超过这个字节数时压缩，小于等于0时使用默认值 DefaultCompressThreshold
+// 收到的消息只要标记为已压缩就会自动解压，与这里的配置无关
+func (m *MsgParser) SetCompress(name string, threshold int) error {
+	if name == "" {
+		m.compress = nil
+		return nil
+	}
+	info, err := getCompressor(name)
+	if err != nil {
+		return err
+	}
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	m.compress = info
+	m.compressThreshold = threshold
+	return nil
+}
+
+// SetMaxDecompressLen 修改解压后消息数据最大字节数，默认 DefaultMaxDecompressLen
+// n 小于等于0时不做修改
+func (m *MsgParser) SetMaxDecompressLen(n int) {
+	if n > 0 {
+		m.maxDecompressLen = n
+	}
+}
+
+// HeadLen 消息头占用的字节数
+func (m *MsgParser) HeadLen(head *MsgHead) int {
+	n := 2 + m.msgIDLen
+	if head.HasFlag(FlagRequest | FlagResponse) {
+		n += 4
+	}
+	if head.HasFlag(FlagForward) {
+		n += 8
+	}
+	if head.HasFlag(FlagUser) {
+		n += 8
+	}
+	if head.HasFlag(FlagFragment) {
+		n += 4
+	}
+	return n
+}
+
 // Marshal 消息序列化
 // msgID 消息号
 // msg 消息数据
 // n 返回得数据切片前面填充几个空字节
-func (m *MsgParser) Marshal(msgID uint16, msg interface{}, n int) ([]byte, error) {
+func (m *MsgParser) Marshal(msgID uint32, msg interface{}, n int) ([]byte, error) {
+	return m.MarshalHead(&MsgHead{MsgID: msgID}, msg, n)
+}
+
+// MarshalHead 消息序列化
+// head 消息头
+// msg 消息数据
+// n 返回得数据切片前面填充几个空字节
+func (m *MsgParser) MarshalHead(head *MsgHead, msg interface{}, n int) ([]byte, error) {
 	et := encoding.TypeTest(msg)     // 数据类型
 	p, _ := encoding.GetEncoding(et) // 获取编码器
 	data, err := p.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
+	return m.marshal(head, et, data, n)
+}
+
+func (m *MsgParser) marshal(head *MsgHead, et encoding.EncodeType, data []byte, n int) ([]byte, error) {
+	if m.msgIDLen == 2 && head.MsgID > math.MaxUint16 {
+		return nil, errors.New("msgID out of range")
+	}
 
-	bs := getBytesN(n + 4 + len(data))
+	flags := head.Flags &^ FlagCompressed
+	var id []byte
+	if m.compress != nil && len(data) > m.compressThreshold {
+		c, err := m.compress.c.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		// 压缩后没有变小时不压缩
+		if len(c)+1 < len(data) {
+			flags |= FlagCompressed
+			id = []byte{m.compress.id}
+			data = c
+		}
+	}
+
+	l := m.HeadLen(head)
+	bs := getBytesN(n + l + len(id) + len(data))
+	m.putHead(bs[n:], head, et, flags)
+	copy(bs[n+l:], id)
+	copy(bs[n+l+len(id):], data)
+	return bs, nil
+}
+
+// putHead 写入消息头，bs 的长度至少为 HeadLen
+// flags 标记位，其它字段根据 flags 写入
+func (m *MsgParser) putHead(bs []byte, head *MsgHead, et encoding.EncodeType, flags uint8) {
+	m.endian.PutUint16(bs, uint16(et)|uint16(flags)<<8) // 数据类型及标记位2字节
+	i := 2
+	if m.msgIDLen == 2 {
+		m.endian.PutUint16(bs[i:], uint16(head.MsgID)) // 消息号2字节
+	} else {
+		m.endian.PutUint32(bs[i:], head.MsgID) // 消息号4字节
+	}
+	i += m.msgIDLen
+	if flags&(FlagRequest|FlagResponse) != 0 {
+		m.endian.PutUint32(bs[i:], head.Seq) // rpc序号4字节
+		i += 4
+	}
+	if flags&FlagForward != 0 {
+		m.endian.PutUint64(bs[i:], uint64(head.Client)) // 客户端连接标识8字节
+		i += 8
+	}
+	if flags&FlagUser != 0 {
+		m.endian.PutUint64(bs[i:], head.UID) // 用户ID8字节
+		i += 8
+	}
+	if flags&FlagFragment != 0 {
+		m.endian.PutUint16(bs[i:], head.FragIndex)   // 分片序号2字节
+		m.endian.PutUint16(bs[i+2:], head.FragTotal) // 分片总数2字节
+	}
+}
 
-	m.endian.PutUint16(bs[n:], uint16(et)) // 数据类型2字节
-	m.endian.PutUint16(bs[n+2:], msgID)    // 消息号2字节
-	copy(bs[n+4:], data)
-	return bs, err
+// body 获取消息数据，已压缩的数据自动解压，并去掉压缩标记
+// head 消息头
+// data 消息头后面的数据
+func (m *MsgParser) body(head *MsgHead, data []byte) ([]byte, error) {
+	if !head.HasFlag(FlagCompressed) {
+		return data, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("compressed message too short")
+	}
+	info, ok := compressors[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("compressor not found: %d", data[0])
+	}
+	data, err := info.c.Decompress(data[1:], m.maxDecompressLen)
+	if err != nil {
+		return nil, err
+	}
+	head.Flags &^= FlagCompressed
+	return data, nil
 }
 
-func (m *MsgParser) unmarshal(data []byte) (id uint16, et encoding.EncodeType) {
-	et = encoding.EncodeType(m.endian.Uint16(data))
-	id = m.endian.Uint16(data[2:])
+func (m *MsgParser) unmarshal(data []byte) (head *MsgHead, et encoding.EncodeType, err error) {
+	if len(data) < 2+m.msgIDLen {
+		return nil, 0, errors.New("message head too short")
+	}
+	v := m.endian.Uint16(data)
+	et = encoding.EncodeType(v & 0xFF)
+	head = &MsgHead{
+		Flags: uint8(v >> 8),
+	}
+	if len(data) < m.HeadLen(head) {
+		return nil, 0, errors.New("message head too short")
+	}
+	i := 2
+	if m.msgIDLen == 2 {
+		head.MsgID = uint32(m.endian.Uint16(data[i:]))
+	} else {
+		head.MsgID = m.endian.Uint32(data[i:])
+	}
+	i += m.msgIDLen
+	if head.HasFlag(FlagRequest | FlagResponse) {
+		head.Seq = m.endian.Uint32(data[i:])
+		i += 4
+	}
+	if head.HasFlag(FlagForward) {
+		head.Client = SessionKey(m.endian.Uint64(data[i:]))
+		i += 8
+	}
+	if head.HasFlag(FlagUser) {
+		head.UID = m.endian.Uint64(data[i:])
+		i += 8
+	}
+	if head.HasFlag(FlagFragment) {
+		head.FragIndex = m.endian.Uint16(data[i:])
+		head.FragTotal = m.endian.Uint16(data[i+2:])
+	}
 	return
 }
 
@@ -63,18 +283,34 @@ func (m *MsgParser) unmarshal(data []byte) (id uint16, et encoding.EncodeType) {
 // data 序列化数据
 // n 解析时跳过开头的几个字节
 // 返回消息号和消息结构体的指针
-func (m *MsgParser) Unmarshal(data []byte, n int) (msgID uint16, msg interface{}, err error) {
+func (m *MsgParser) Unmarshal(data []byte, n int) (msgID uint32, msg interface{}, err error) {
+	var head *MsgHead
+	head, msg, err = m.UnmarshalHead(data, n)
+	if head != nil {
+		msgID = head.MsgID
+	}
+	return
+}
+
+// UnmarshalHead 消息解析
+// data 序列化数据
+// n 解析时跳过开头的几个字节
+// 返回消息头和消息结构体的指针
+func (m *MsgParser) UnmarshalHead(data []byte, n int) (head *MsgHead, msg interface{}, err error) {
 	var et encoding.EncodeType
-	msgID, et = m.unmarshal(data[n:])
-	msg = CreateMessage(msgID)
+	head, et, err = m.unmarshal(data[n:])
+	if err != nil {
+		return nil, nil, err
+	}
+	msg = CreateMessage(head.MsgID)
 	if msg == nil {
-		return msgID, nil, NewError(errors.New("msgID unregister"), ErrorTypeMsgID, msgID)
+		return head, nil, NewError(errors.New("msgID unregister"), ErrorTypeMsgID, head.MsgID)
 	}
-	p, has := encoding.GetEncoding(et)
-	if !has {
-		return msgID, nil, NewError(errors.New("encoder error"), ErrorTypeEncoder, msgID)
+	body, err := m.body(head, data[n+m.HeadLen(head):])
+	if err != nil {
+		return head, nil, err
 	}
-	return msgID, msg, p.Unmarshal(data[n+4:], msg)
+	return head, msg, unmarshalMsg(head.MsgID, et, body, msg)
 }
 
 // UnmarshalUnregister 未注册的消息解析
@@ -82,14 +318,18 @@ func (m *MsgParser) Unmarshal(data []byte, n int) (msgID uint16, msg interface{}
 // msg 消息结构体的指针
 // n 解析时跳过开头的几个字节
 // 返回消息号
-func (m *MsgParser) UnmarshalUnregister(data []byte, msg interface{}, n int) (msgID uint16, err error) {
+func (m *MsgParser) UnmarshalUnregister(data []byte, msg interface{}, n int) (msgID uint32, err error) {
+	var head *MsgHead
 	var et encoding.EncodeType
-	msgID, et = m.unmarshal(data[n:])
-	p, has := encoding.GetEncoding(et)
-	if !has {
-		return msgID, NewError(errors.New("encoder error"), ErrorTypeEncoder, msgID)
+	head, et, err = m.unmarshal(data[n:])
+	if err != nil {
+		return 0, err
+	}
+	body, err := m.body(head, data[n+m.HeadLen(head):])
+	if err != nil {
+		return head.MsgID, err
 	}
-	return msgID, p.Unmarshal(data[n+4:], msg)
+	return head.MsgID, unmarshalMsg(head.MsgID, et, body, msg)
 }
 
 var gMsgParser = NewMsgParser()
@@ -97,14 +337,14 @@ var gMsgParser = NewMsgParser()
 // Marshal 消息序列化
 // msgID 消息号
 // msg 消息数据
-func Marshal(msgID uint16, msg interface{}) ([]byte, error) {
+func Marshal(msgID uint32, msg interface{}) ([]byte, error) {
 	return gMsgParser.Marshal(msgID, msg, 0)
 }
 
 // Unmarshal 消息解析
 // data 序列化数据
 // 返回消息号和消息结构体的指针
-func Unmarshal(data []byte) (msgID uint16, msg interface{}, err error) {
+func Unmarshal(data []byte) (msgID uint32, msg interface{}, err error) {
 	return gMsgParser.Unmarshal(data, 0)
 }
 
@@ -112,6 +352,6 @@ func Unmarshal(data []byte) (msgID uint16, msg interface{}, err error) {
 // data 序列化数据
 // msg 消息结构体的指针
 // 返回消息号
-func UnmarshalUnregister(data []byte, msg interface{}) (msgID uint16, err error) {
+func UnmarshalUnregister(data []byte, msg interface{}) (msgID uint32, err error) {
 	return gMsgParser.UnmarshalUnregister(data, msg, 0)
 }
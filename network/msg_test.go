@@ -1,6 +1,7 @@
 package network_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/skeletongo/cube/network"
@@ -8,14 +9,20 @@ import (
 
 var gMsgParser = network.NewMsgParser()
 
+// setMessage 在默认注册表中注册消息，重复运行测试时只注册一次
+func setMessage(msgID uint32) {
+	if network.CreateMessage(msgID) == nil {
+		network.SetMessage(msgID, new(D))
+	}
+}
+
 type D struct {
 	Name string
 	Age  int
 }
 
 func TestMarshal(t *testing.T) {
-	network.SetHandlerFunc(1, new(D), func(c *network.Context) {
-	})
+	setMessage(1)
 
 	data, err := gMsgParser.Marshal(1, &D{
 		Name: "Tom",
@@ -45,3 +52,65 @@ func TestUnmarshalUnregister(t *testing.T) {
 	id, err := gMsgParser.UnmarshalUnregister(data, msg, 2)
 	t.Logf("msgID:%v Msg:%v Err:%v\n", id, *msg, err)
 }
+
+func TestMarshalMsgIDLen(t *testing.T) {
+	setMessage(100000)
+
+	if _, err := gMsgParser.Marshal(100000, &D{}, 2); err == nil {
+		t.Error("msgID out of range")
+		return
+	}
+
+	p := network.NewMsgParser()
+	p.SetMsgIDLen(4)
+	data, err := p.MarshalHead(&network.MsgHead{
+		Flags: network.FlagRequest,
+		MsgID: 100000,
+		Seq:   7,
+	}, &D{
+		Name: "Tom",
+		Age:  20,
+	}, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	head, msg, err := p.UnmarshalHead(data, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if head.MsgID != 100000 || head.Seq != 7 || !head.HasFlag(network.FlagRequest) || msg.(*D).Name != "Tom" {
+		t.Errorf("head:%v msg:%v", head, msg)
+	}
+}
+
+func TestMarshalCompress(t *testing.T) {
+	setMessage(2)
+
+	p := network.NewMsgParser()
+	if err := p.SetCompress("gzip", 64); err != nil {
+		t.Error(err)
+		return
+	}
+	name := strings.Repeat("Tom", 1000)
+	data, err := p.Marshal(2, &D{Name: name}, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(data) > len(name) {
+		t.Errorf("not compressed, len:%v", len(data))
+	}
+
+	// 解析时不需要压缩配置
+	head, msg, err := gMsgParser.UnmarshalHead(data, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if head.HasFlag(network.FlagCompressed) || msg.(*D).Name != name {
+		t.Errorf("head:%v", head)
+	}
+}
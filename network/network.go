@@ -9,7 +9,7 @@ import (
 )
 
 const (
-	TimeRestart = 5 * time.Second // 网络服务延迟重启时间间隔
+	TimeRestart = 5 * time.Second // 网络服务默认的延迟重启时间间隔，见 RestartPolicy
 	Capacity    = 10
 )
 
@@ -22,15 +22,22 @@ type Service interface {
 
 // Network 网络服务管理器
 type Network struct {
-	service  map[ServerKey]Service
-	configCh chan *ServiceConfig
-	close    bool
+	service   map[ServerKey]Service
+	configCh  chan *ServiceConfig
+	removeCh  chan ServerKey
+	removed   map[ServerKey]struct{} // 已经移除，等待关闭的服务
+	statuses  map[ServerKey]*serviceStatus
+	discovery *Discovery
+	close     bool
 }
 
 func NewNetwork() *Network {
 	return &Network{
 		service:  make(map[ServerKey]Service, Capacity),
 		configCh: make(chan *ServiceConfig, Capacity),
+		removeCh: make(chan ServerKey, Capacity),
+		removed:  make(map[ServerKey]struct{}),
+		statuses: make(map[ServerKey]*serviceStatus),
 	}
 }
 
@@ -50,6 +57,12 @@ func (n *Network) newService(config *ServiceConfig) Service {
 		switch config.Protocol {
 		case "ws", "wss":
 			s = NewWSClient(n, config)
+		case "kcp":
+			s = NewKCPClient(n, config)
+		case "unix":
+			s = NewUnixClient(n, config)
+		case "pipe":
+			s = NewPipeClient(n, config)
 		default:
 			s = NewTCPClient(n, config)
 		}
@@ -57,6 +70,12 @@ func (n *Network) newService(config *ServiceConfig) Service {
 		switch config.Protocol {
 		case "ws", "wss":
 			s = NewWSServer(n, config)
+		case "kcp":
+			s = NewKCPServer(n, config)
+		case "unix":
+			s = NewUnixServer(n, config)
+		case "pipe":
+			s = NewPipeServer(n, config)
 		default:
 			s = NewTCPServer(n, config)
 		}
@@ -69,9 +88,14 @@ func (n *Network) newService(config *ServiceConfig) Service {
 
 	if err := s.Start(); err != nil {
 		log.WithField("ServiceInfo", config).Errorf("network service start error: %v", err)
+		n.restart(config, err)
 		return nil
 	}
 	n.service[config.Key()] = s
+	n.started(config)
+	if n.discovery != nil && !config.IsClient {
+		n.discovery.register(config)
+	}
 	return s
 }
 
@@ -79,6 +103,9 @@ func (n *Network) Init() {
 	for i := 0; i < len(Config.Services); i++ {
 		n.newService(Config.Services[i])
 	}
+	if n.discovery != nil {
+		n.discovery.Start()
+	}
 }
 
 func (n *Network) AfterInit() {
@@ -88,15 +115,33 @@ func (n *Network) AfterInit() {
 func (n *Network) Update() {
 	select {
 	case config := <-n.configCh:
+		if _, ok := n.removed[config.Key()]; ok {
+			// 服务还没有关闭完成，稍后重试
+			time.AfterFunc(TimeRestart, func() {
+				n.NewService(config)
+			})
+			return
+		}
 		_, ok := n.service[config.Key()]
 		if !n.close && !ok {
 			n.newService(config)
 		}
 
+	case key := <-n.removeCh:
+		s, ok := n.service[key]
+		if !ok {
+			return
+		}
+		if _, ok = n.removed[key]; !ok {
+			n.removed[key] = struct{}{}
+			s.Shutdown()
+		}
+
 	default:
 		for _, v := range n.service {
 			v.Update()
 		}
+		gResume.update(time.Now())
 	}
 }
 
@@ -110,13 +155,19 @@ func (n *Network) Close() {
 	}
 	n.close = true
 
+	if n.discovery != nil {
+		n.discovery.Close()
+	}
+
 	if len(n.service) == 0 {
-		module.Release(n)
+		n.release()
 		return
 	}
 
-	for _, v := range n.service {
-		v.Shutdown()
+	for k, v := range n.service {
+		if _, ok := n.removed[k]; !ok {
+			v.Shutdown()
+		}
 	}
 }
 
@@ -125,22 +176,43 @@ func (n *Network) Close() {
 // config 服务配置
 func (n *Network) Release(config *ServiceConfig) {
 	delete(n.service, config.Key())
-	if !n.close {
-		time.AfterFunc(TimeRestart, func() {
-			n.NewService(config)
-		})
+	if n.discovery != nil && !config.IsClient {
+		n.discovery.deregister(config)
+	}
+	if _, ok := n.removed[config.Key()]; ok {
+		delete(n.removed, config.Key())
+		if n.discovery != nil && config.IsClient {
+			n.discovery.release(config)
+		}
+		n.stopped(config)
+	} else if !n.close {
+		n.restart(config, errServiceClosed)
 		return
+	} else {
+		n.stopped(config)
 	}
-	if len(n.service) == 0 {
+	if n.close && len(n.service) == 0 {
+		n.release()
+	}
+}
+
+// release 网络服务全部关闭，等待服务发现注销本地服务后确认模块关闭
+func (n *Network) release() {
+	if n.discovery == nil {
 		module.Release(n)
+		return
 	}
+	go func() {
+		<-n.discovery.done
+		module.Release(n)
+	}()
 }
 
 // NewService 新增网络服务
 // config 服务配置
 func (n *Network) NewService(config *ServiceConfig) {
 	select {
-	case gNetwork.configCh <- config:
+	case n.configCh <- config:
 	default:
 		log.Warningf("Network: service channel full, retrying in %v", TimeRestart)
 		time.AfterFunc(TimeRestart, func() {
@@ -149,8 +221,27 @@ func (n *Network) NewService(config *ServiceConfig) {
 	}
 }
 
+// RemoveService 关闭并移除网络服务，移除的服务不会自动重启
+// key 服务标识
+func (n *Network) RemoveService(key ServerKey) {
+	select {
+	case n.removeCh <- key:
+	default:
+		log.Warningf("Network: remove channel full, retrying in %v", TimeRestart)
+		time.AfterFunc(TimeRestart, func() {
+			n.RemoveService(key)
+		})
+	}
+}
+
 // NewService 新增网络服务
 // config 服务配置
 func NewService(config *ServiceConfig) {
 	gNetwork.NewService(config)
 }
+
+// RemoveService 关闭并移除网络服务，移除的服务不会自动重启
+// key 服务标识
+func RemoveService(key ServerKey) {
+	gNetwork.RemoveService(key)
+}
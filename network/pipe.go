@@ -0,0 +1,117 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// 进程内的内存管道连接，基于 net.Pipe，不使用任何套接字
+// 用于同一个进程中的多个 Network 之间通信，或者消息处理方法的集成测试
+// 服务端和客户端通过 ServiceConfig.Path 匹配，为空时使用 Ip:Port
+
+var (
+	pipeMu        sync.Mutex
+	pipeListeners = make(map[string]*pipeListener)
+)
+
+type pipeAddr string
+
+func (p pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (p pipeAddr) String() string {
+	return string(p)
+}
+
+// pipeListener 内存管道服务端
+type pipeListener struct {
+	name    string
+	connCh  chan net.Conn
+	die     chan struct{}
+	dieOnce sync.Once
+}
+
+func listenPipe(name string) (net.Listener, error) {
+	pipeMu.Lock()
+	defer pipeMu.Unlock()
+	if _, ok := pipeListeners[name]; ok {
+		return nil, fmt.Errorf("pipe address already in use: %s", name)
+	}
+	l := &pipeListener{
+		name:   name,
+		connCh: make(chan net.Conn),
+		die:    make(chan struct{}),
+	}
+	pipeListeners[name] = l
+	return l, nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.die:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.dieOnce.Do(func() {
+		pipeMu.Lock()
+		if pipeListeners[l.name] == l {
+			delete(pipeListeners, l.name)
+		}
+		pipeMu.Unlock()
+		close(l.die)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
+
+func dialPipe(name string) (net.Conn, error) {
+	pipeMu.Lock()
+	l, ok := pipeListeners[name]
+	pipeMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("pipe connection refused: %s", name)
+	}
+	server, client := net.Pipe()
+	select {
+	case l.connCh <- server:
+		return client, nil
+	case <-l.die:
+		server.Close()
+		client.Close()
+		return nil, fmt.Errorf("pipe connection refused: %s", name)
+	}
+}
+
+func pipeName(config *ServiceConfig, addr string) string {
+	if config.Path != "" {
+		return config.Path
+	}
+	return addr
+}
+
+// NewPipeServer 内存管道服务端
+func NewPipeServer(network *Network, config *ServiceConfig) *TCPServer {
+	t := NewTCPServer(network, config)
+	t.listen = func(addr string) (net.Listener, error) {
+		return listenPipe(pipeName(config, addr))
+	}
+	return t
+}
+
+// NewPipeClient 内存管道客户端
+func NewPipeClient(n *Network, config *ServiceConfig) *TCPClient {
+	t := NewTCPClient(n, config)
+	t.connect = func(addr string) (net.Conn, error) {
+		return dialPipe(pipeName(config, addr))
+	}
+	return t
+}
@@ -0,0 +1,461 @@
+package network
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skeletongo/cube/base"
+	"github.com/skeletongo/cube/module"
+)
+
+type pipeMsg struct {
+	Text string
+}
+
+// testMsgHandler 每个测试使用单独的消息注册表，测试结束后删除
+func testMsgHandler(t *testing.T) *MsgHandler {
+	name := t.Name()
+	t.Cleanup(func() { delete(gMsgHandlers, name) })
+	return GetMsgHandler(name)
+}
+
+func TestPipe(t *testing.T) {
+	h := testMsgHandler(t)
+	h.SetHandlerFunc(1, new(pipeMsg), func(c *Context) {
+		c.Send(2, &pipeMsg{Text: c.Msg.(*pipeMsg).Text + " pong"})
+	})
+	var reply string
+	h.SetHandlerFunc(2, new(pipeMsg), func(c *Context) {
+		reply = c.Msg.(*pipeMsg).Text
+	})
+
+	server := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 1, ID: 1},
+		Protocol:   "pipe",
+		Path:       "pipe_test",
+		Handler:    t.Name(),
+	}
+	client := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:   "pipe",
+		Path:       "pipe_test",
+		Handler:    t.Name(),
+		IsClient:   true,
+		ClientNum:  1,
+	}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	n1, n2 := NewNetwork(), NewNetwork()
+	if n1.newService(server) == nil {
+		t.Fatal("server start error")
+	}
+	defer n1.service[server.Key()].Shutdown()
+	c, ok := n2.newService(client).(*TCPClient)
+	if !ok {
+		t.Fatal("client start error")
+	}
+	defer c.Shutdown()
+
+	sent := false
+	deadline := time.Now().Add(5 * time.Second)
+	for reply == "" && time.Now().Before(deadline) {
+		n1.Update()
+		n2.Update()
+		if !sent {
+			for s := range c.sessions {
+				s.Send(1, &pipeMsg{Text: "ping"})
+				sent = true
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if reply != "ping pong" {
+		t.Errorf("reply: %q", reply)
+	}
+}
+
+func TestErrorMsgIDPacket(t *testing.T) {
+	testMsgHandler(t)
+	var unregister, packet pipeMsg
+	var msgID uint32
+	RegisterMiddle(t.Name(), func() Middle {
+		return &MiddleFunc{
+			ErrorMsgID: func(c *Context) {
+				// Packet 不包括长度字段，和之前的版本一样可以直接解析
+				msgID, _ = UnmarshalUnregister(c.Packet, &packet)
+				_ = c.UnmarshalPacket(&unregister)
+			},
+		}
+	})
+
+	server := &ServiceConfig{
+		ServerInfo:  ServerInfo{Area: 1, Type: 1, ID: 1},
+		Protocol:    "pipe",
+		Path:        t.Name(),
+		Handler:     t.Name(),
+		MiddleChain: []string{t.Name()},
+	}
+	client := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		IsClient:   true,
+		ClientNum:  1,
+	}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	n1, n2 := NewNetwork(), NewNetwork()
+	if n1.newService(server) == nil {
+		t.Fatal("server start error")
+	}
+	defer n1.service[server.Key()].Shutdown()
+	c, ok := n2.newService(client).(*TCPClient)
+	if !ok {
+		t.Fatal("client start error")
+	}
+	defer c.Shutdown()
+
+	sent := false
+	deadline := time.Now().Add(5 * time.Second)
+	for msgID == 0 && time.Now().Before(deadline) {
+		n1.Update()
+		n2.Update()
+		if !sent {
+			for s := range c.sessions {
+				s.Send(1, &pipeMsg{Text: "unregister"})
+				sent = true
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if msgID != 1 || packet.Text != "unregister" || unregister.Text != "unregister" {
+		t.Errorf("msgID: %v packet: %q unregister: %q", msgID, packet.Text, unregister.Text)
+	}
+}
+
+// testPipe 启动管道协议的服务端和客户端，分别运行在不同的网络模块上，测试结束后关闭
+func testPipe(t *testing.T, server, client *ServiceConfig) (*Network, *Network, *TCPClient) {
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.init(); err != nil {
+		t.Fatal(err)
+	}
+	n1, n2 := NewNetwork(), NewNetwork()
+	if n1.newService(server) == nil {
+		t.Fatal("server start error")
+	}
+	t.Cleanup(func() {
+		// 测试中可能已经关闭了服务
+		if s, ok := n1.service[server.Key()]; ok {
+			s.Shutdown()
+		}
+	})
+	c, ok := n2.newService(client).(*TCPClient)
+	if !ok {
+		t.Fatal("client start error")
+	}
+	t.Cleanup(func() { c.Shutdown() })
+	return n1, n2, c
+}
+
+// testConfigs 管道协议的服务端和客户端配置，使用 testMsgHandler 注册的消息
+func testConfigs(t *testing.T) (server, client *ServiceConfig) {
+	server = &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 1, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		Handler:    t.Name(),
+	}
+	client = &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		Handler:    t.Name(),
+		IsClient:   true,
+		ClientNum:  1,
+	}
+	return
+}
+
+// testModule 启动module节点，定时器及 module.Obj.SendFunc 的回调在这个节点上执行，测试结束后关闭
+func testModule(t *testing.T) {
+	old := module.Obj
+	module.Obj = base.NewObject("module", &base.Options{}, nil)
+	module.Obj.Run()
+	t.Cleanup(func() {
+		module.Obj.Close()
+		<-module.Obj.Closed
+		module.Obj = old
+	})
+}
+
+// testOnModule 在module节点上执行方法，没有启动module节点时直接执行
+func testOnModule(f func()) {
+	if module.Obj == nil {
+		f()
+		return
+	}
+	done := make(chan struct{})
+	module.Obj.SendFunc(func(o *base.Object) {
+		defer close(done)
+		f()
+	})
+	<-done
+}
+
+// testFilter 给服务追加过滤器，不修改全局的过滤器列表
+func testFilter(sc *ServiceConfig, f Filter) {
+	for i := 0; i < int(MaxOpportunity); i++ {
+		if v := f.Get(Opportunity(i)); v != nil {
+			sc.filterChain.functions[i] = append(sc.filterChain.functions[i], v)
+		}
+	}
+}
+
+// testPipeSession 使用内存管道的连接，没有启动发送协程，recv 返回对端读取到的消息号1的数据包数量，连接关闭后返回
+func testPipeSession(t *testing.T, sc *ServiceConfig) (s *Session, recv <-chan int) {
+	conn, peer := net.Pipe()
+	s = NewSession(sc)
+	var err error
+	if s.agent, err = NewTCPSession(s, conn); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int, 1)
+	go func() {
+		n := 0
+		for {
+			data, err := sc.codec.Read(peer)
+			if err != nil {
+				ch <- n
+				return
+			}
+			if head, _, _, err := sc.codec.Unmarshal(data); err == nil && head.MsgID == 1 {
+				n++
+			}
+		}
+	}()
+	return s, ch
+}
+
+// pipeTest 管道协议的服务端和客户端，分别运行在不同的网络模块上
+type pipeTest struct {
+	t      *testing.T
+	n1, n2 *Network
+	server *TCPServer
+	client *TCPClient
+}
+
+func newPipeTest(t *testing.T, server, client *ServiceConfig) *pipeTest {
+	p := &pipeTest{t: t}
+	p.n1, p.n2, p.client = testPipe(t, server, client)
+	p.server = p.n1.service[server.Key()].(*TCPServer)
+	return p
+}
+
+// wait 在module节点上更新网络模块直到满足条件
+func (p *pipeTest) wait(what string, f func() bool) {
+	p.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok := false
+		testOnModule(func() {
+			p.n1.Update()
+			p.n2.Update()
+			ok = f()
+		})
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			p.t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connected 等待建立连接，返回服务端和客户端的连接
+func (p *pipeTest) connected() (ss, cs *Session) {
+	p.wait("connected", func() bool {
+		for s := range p.server.sessions {
+			ss = s
+		}
+		for s := range p.client.sessions {
+			cs = s
+		}
+		return ss != nil && cs != nil
+	})
+	return
+}
+
+type bigMsg struct {
+	Data []byte
+}
+
+func TestPipeFragment(t *testing.T) {
+	h := testMsgHandler(t)
+	var recv []byte
+	h.SetHandlerFunc(1, new(bigMsg), func(c *Context) {
+		recv = c.Msg.(*bigMsg).Data
+	})
+
+	// 分片数量超过发送队列长度，默认的 PolicyClose 也不能关闭连接
+	server := &ServiceConfig{
+		ServerInfo:       ServerInfo{Area: 1, Type: 1, ID: 1},
+		Protocol:         "pipe",
+		Path:             t.Name(),
+		Handler:          t.Name(),
+		MaxReassembleLen: 8 << 20,
+	}
+	client := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		Handler:    t.Name(),
+		IsClient:   true,
+		ClientNum:  1,
+		MaxSend:    16,
+	}
+	n1, n2, c := testPipe(t, server, client)
+
+	data := make([]byte, 5<<20)
+	rand.Read(data)
+	sent := false
+	deadline := time.Now().Add(10 * time.Second)
+	for recv == nil && time.Now().Before(deadline) {
+		n1.Update()
+		n2.Update()
+		if !sent {
+			for s := range c.sessions {
+				if err := s.sendHead(&MsgHead{MsgID: 1}, &bigMsg{Data: data}); err != nil {
+					t.Fatal(err)
+				}
+				if s.QueueStats().SendSpill == 0 {
+					t.Fatal("fragments not spilled")
+				}
+				sent = true
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(recv, data) {
+		t.Fatalf("reassembled data mismatch: %d %d", len(recv), len(data))
+	}
+	for s := range c.sessions {
+		if r := s.CloseReason(); r != CloseNone {
+			t.Fatalf("session closed: %v", r)
+		}
+	}
+}
+
+func TestDropOldestFragment(t *testing.T) {
+	sc := &ServiceConfig{Protocol: "pipe", Path: t.Name(), SendPolicy: PolicyDropOldest}
+	if err := sc.init(); err == nil {
+		t.Fatal("drop_oldest with fragmentation should fail")
+	}
+	sc = &ServiceConfig{Protocol: "pipe", Path: t.Name(), SendPolicy: PolicyDropOldest, FragmentSize: -1}
+	if err := sc.init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerIsolation(t *testing.T) {
+	// 消息1只在服务A的注册表中注册，服务B收到时按未注册的消息处理
+	var handledA int
+	a := GetMsgHandler(t.Name() + "/a")
+	t.Cleanup(func() { delete(gMsgHandlers, t.Name()+"/a") })
+	a.SetHandlerFunc(1, new(pipeMsg), func(c *Context) { handledA++ })
+	b := testMsgHandler(t)
+	var marked bool
+	b.SetHandlerFunc(2, new(pipeMsg), func(c *Context) { marked = true })
+
+	server, client := testConfigs(t)
+	other := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 3, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name() + "/a",
+		Handler:    t.Name() + "/a",
+	}
+	if err := other.init(); err != nil {
+		t.Fatal(err)
+	}
+	p := newPipeTest(t, server, client)
+	if p.n1.newService(other) == nil {
+		t.Fatal("server A start error")
+	}
+	t.Cleanup(func() { p.n1.service[other.Key()].Shutdown() })
+
+	var errMsgIDs []uint32
+	testFilter(server, &FilterFunc{ErrorMsgID: func(c *Context) bool {
+		errMsgIDs = append(errMsgIDs, c.MsgID)
+		return true
+	}})
+
+	_, cs := p.connected()
+	cs.Send(1, &pipeMsg{Text: "a"})
+	cs.Send(2, &pipeMsg{Text: "b"})
+	p.wait("marker", func() bool { return marked })
+	if handledA != 0 || len(errMsgIDs) != 1 || errMsgIDs[0] != 1 {
+		t.Fatalf("handled by A %d, unregistered %v", handledA, errMsgIDs)
+	}
+}
+
+func TestFragmentUnauthorized(t *testing.T) {
+	h := testMsgHandler(t)
+	var recv []byte
+	h.SetHandlerFunc(1, new(bigMsg), func(c *Context) { recv = c.Msg.(*bigMsg).Data })
+	var marked int
+	h.SetHandlerFunc(2, new(pipeMsg), func(c *Context) { marked++ })
+
+	server, client := testConfigs(t)
+	server.Auth.Whitelist = []uint32{2, 3}
+	// 每次更新只处理一个数据包，检查每个分片处理后的重组缓存
+	server.MaxRecv, server.RecvPolicy = 1, PolicySpill
+	p := newPipeTest(t, server, client)
+	testFilter(server, gFilterMgr.filterCreators["auth"]())
+	ss, cs := p.connected()
+
+	// 认证之前的消息1和未注册的消息3的分片都被丢弃，不缓存数据也不关闭连接
+	data := make([]byte, 64<<10)
+	rand.Read(data)
+	buffered := 0
+	for _, id := range []uint32{1, 3} {
+		if err := cs.sendHead(&MsgHead{MsgID: id}, &bigMsg{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+		cs.Send(2, &pipeMsg{})
+		want := marked + 1
+		p.wait("marker", func() bool {
+			if ss.fragLen > buffered {
+				buffered = ss.fragLen
+			}
+			return marked == want
+		})
+	}
+	if recv != nil || buffered != 0 || len(ss.frags) != 0 || ss.CloseReason() != CloseNone {
+		t.Fatalf("recv %d buffered %d frags %d closed %v", len(recv), buffered, len(ss.frags), ss.CloseReason())
+	}
+
+	// 认证通过后正常重组
+	testOnModule(func() { _ = ss.Authenticate(&Identity{}) })
+	if err := cs.sendHead(&MsgHead{MsgID: 1}, &bigMsg{Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	p.wait("reassembled", func() bool { return recv != nil })
+	if !bytes.Equal(recv, data) {
+		t.Fatal("reassembled data mismatch")
+	}
+}
@@ -0,0 +1,287 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 超过限流限制时的处理方式，见 RateLimit.Action
+const (
+	LimitDrop       = "drop"       // 丢弃消息或拒绝连接
+	LimitWarn       = "warn"       // 只打印日志
+	LimitDisconnect = "disconnect" // 断开连接
+	LimitBan        = "ban"        // 断开连接并在 RateLimit.BanTime 内拒绝这个IP的连接
+)
+
+// MsgRateLimit 单个消息号的限流配置
+type MsgRateLimit struct {
+	MsgID uint32
+	Rate  float64 // 每个连接每秒允许收到的消息数量
+	Burst int     // 允许的突发消息数量，默认等于Rate
+}
+
+// RateLimit 限流过滤器配置，在 FilterChain 中加入 "ratelimit" 后生效
+// 收到的消息在心跳、转发及过滤器之前限流，分片消息重组后计数一次，可信网关链路上转发的客户端消息由网关限流
+type RateLimit struct {
+	Rate      float64         // 每个连接每秒允许收到的消息数量，为0时不限制
+	Burst     int             // 每个连接允许的突发消息数量，默认等于Rate
+	Msgs      []*MsgRateLimit // 单个消息号的限流配置，和连接的限制同时生效
+	ConnRate  float64         // 每个IP每秒允许建立的连接数量，为0时不限制
+	ConnBurst int             // 每个IP允许的突发连接数量，默认等于ConnRate
+	Action    string          // 超过限制时的处理方式 "drop" "warn" "disconnect" "ban"，默认 "drop"
+	BanTime   time.Duration   // Action为 "ban" 时封禁IP的时长，单位分钟，默认10分钟
+
+	msgs map[uint32]*MsgRateLimit
+}
+
+func (r *RateLimit) init() error {
+	if r.Action == "" {
+		r.Action = LimitDrop
+	}
+	switch r.Action {
+	case LimitDrop, LimitWarn, LimitDisconnect, LimitBan:
+	default:
+		return fmt.Errorf("rate limit action %s not found", r.Action)
+	}
+	if r.Burst <= 0 {
+		r.Burst = burst(r.Rate)
+	}
+	if r.ConnBurst <= 0 {
+		r.ConnBurst = burst(r.ConnRate)
+	}
+	if r.BanTime > 0 {
+		r.BanTime *= time.Minute
+	} else {
+		r.BanTime = 10 * time.Minute
+	}
+	r.msgs = make(map[uint32]*MsgRateLimit, len(r.Msgs))
+	for _, v := range r.Msgs {
+		if v.Burst <= 0 {
+			v.Burst = burst(v.Rate)
+		}
+		r.msgs[v.MsgID] = v
+	}
+	return nil
+}
+
+func burst(rate float64) int {
+	if rate < 1 {
+		return 1
+	}
+	return int(rate)
+}
+
+// RateLimitStats 限流过滤器的处理次数，用于监控
+type RateLimitStats struct {
+	Dropped      int64 // 丢弃的消息数量
+	Warned       int64 // 只打印日志的次数
+	Disconnected int64 // 断开的连接数量
+	Banned       int64 // 封禁IP的次数
+	Rejected     int64 // 拒绝的连接数量，包括被封禁IP的连接
+}
+
+var rateLimitStats RateLimitStats
+
+// RateLimitCounts 获取限流过滤器的处理次数
+func RateLimitCounts() RateLimitStats {
+	return RateLimitStats{
+		Dropped:      atomic.LoadInt64(&rateLimitStats.Dropped),
+		Warned:       atomic.LoadInt64(&rateLimitStats.Warned),
+		Disconnected: atomic.LoadInt64(&rateLimitStats.Disconnected),
+		Banned:       atomic.LoadInt64(&rateLimitStats.Banned),
+		Rejected:     atomic.LoadInt64(&rateLimitStats.Rejected),
+	}
+}
+
+// bucket 令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) allow(rate float64, burst int, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full 令牌桶已满，可以删除
+func (b *bucket) full(rate float64, burst int, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+// sessionLimit 连接的令牌桶，见 Session.allow
+type sessionLimit struct {
+	r    *rateLimiter
+	all  bucket
+	msgs map[uint32]*bucket
+}
+
+// rateLimiter 限流过滤器，每个服务一个实例，只在module节点上调用
+type rateLimiter struct {
+	ips   map[string]*bucket
+	bans  map[string]time.Time // 封禁到期时间
+	sweep time.Time            // 下次清理过期数据的时间
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		ips:  make(map[string]*bucket),
+		bans: make(map[string]time.Time),
+	}
+}
+
+// remoteIP 连接的对端IP，unix及pipe等没有端口的地址直接使用地址
+func remoteIP(s *Session) string {
+	addr := s.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (r *rateLimiter) afterConnected(c *Context) bool {
+	conf := &c.SC.RateLimit
+	now := time.Now()
+	r.clean(conf, now)
+
+	ip := remoteIP(c.Session)
+	if t, ok := r.bans[ip]; ok {
+		if now.Before(t) {
+			atomic.AddInt64(&rateLimitStats.Rejected, 1)
+			log.WithField("SessionInfo", c.Session).Trace("rate limit: ip banned")
+			return false
+		}
+		delete(r.bans, ip)
+	}
+
+	if conf.ConnRate > 0 {
+		b, ok := r.ips[ip]
+		if !ok {
+			b = &bucket{}
+			r.ips[ip] = b
+		}
+		if !b.allow(conf.ConnRate, conf.ConnBurst, now) {
+			log.WithField("SessionInfo", c.Session).Warningf("rate limit: too many connections from %s", ip)
+			switch conf.Action {
+			case LimitWarn:
+				atomic.AddInt64(&rateLimitStats.Warned, 1)
+			case LimitBan:
+				r.ban(conf, ip, now)
+				atomic.AddInt64(&rateLimitStats.Rejected, 1)
+				return false
+			default:
+				atomic.AddInt64(&rateLimitStats.Rejected, 1)
+				return false
+			}
+		}
+	}
+
+	c.limit = &sessionLimit{r: r}
+	return true
+}
+
+// allow 收到的消息是否没有超过限流限制，没有启用限流过滤器时返回true，在处理消息前调用
+func (s *Session) allow(head *MsgHead) bool {
+	l := s.limit
+	if l == nil || head.Client != 0 && s.trusted() {
+		// 可信网关链路上转发的客户端消息由网关限流
+		return true
+	}
+	return l.r.allow(s, l, head.MsgID)
+}
+
+func (r *rateLimiter) allow(s *Session, l *sessionLimit, msgID uint32) bool {
+	conf := &s.SC.RateLimit
+	now := time.Now()
+	allow := conf.Rate <= 0 || l.all.allow(conf.Rate, conf.Burst, now)
+	if m, ok := conf.msgs[msgID]; ok && allow {
+		if l.msgs == nil {
+			l.msgs = make(map[uint32]*bucket)
+		}
+		b, ok := l.msgs[msgID]
+		if !ok {
+			b = &bucket{}
+			l.msgs[msgID] = b
+		}
+		allow = b.allow(m.Rate, m.Burst, now)
+	}
+	if allow {
+		return true
+	}
+
+	log.WithField("SessionInfo", s).Warningf("rate limit: msgID %d", msgID)
+	switch conf.Action {
+	case LimitWarn:
+		atomic.AddInt64(&rateLimitStats.Warned, 1)
+		return true
+	case LimitDisconnect:
+		atomic.AddInt64(&rateLimitStats.Disconnected, 1)
+		_ = s.CloseWithReason(CloseRateLimited)
+	case LimitBan:
+		atomic.AddInt64(&rateLimitStats.Disconnected, 1)
+		r.ban(conf, remoteIP(s), now)
+		_ = s.CloseWithReason(CloseRateLimited)
+	default:
+		atomic.AddInt64(&rateLimitStats.Dropped, 1)
+	}
+	return false
+}
+
+func (r *rateLimiter) afterClosed(c *Context) bool {
+	c.limit = nil
+	return true
+}
+
+func (r *rateLimiter) ban(conf *RateLimit, ip string, now time.Time) {
+	log.Warningf("rate limit: ban %s for %v", ip, conf.BanTime)
+	atomic.AddInt64(&rateLimitStats.Banned, 1)
+	r.bans[ip] = now.Add(conf.BanTime)
+}
+
+// clean 定时清理过期的封禁及已满的令牌桶
+func (r *rateLimiter) clean(conf *RateLimit, now time.Time) {
+	if now.Before(r.sweep) {
+		return
+	}
+	r.sweep = now.Add(time.Minute)
+	for ip, t := range r.bans {
+		if !now.Before(t) {
+			delete(r.bans, ip)
+		}
+	}
+	for ip, b := range r.ips {
+		if b.full(conf.ConnRate, conf.ConnBurst, now) {
+			delete(r.ips, ip)
+		}
+	}
+}
+
+func init() {
+	RegisterFilter("ratelimit", func() Filter {
+		r := newRateLimiter()
+		return &FilterFunc{
+			AfterConnected: r.afterConnected,
+			AfterClosed:    r.afterClosed,
+		}
+	})
+}
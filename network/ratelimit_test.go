@@ -0,0 +1,252 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testLimitSession 使用内存管道的连接，只用于获取对端地址
+func testLimitSession(t *testing.T, sc *ServiceConfig) *Session {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	s := NewSession(sc)
+	var err error
+	if s.agent, err = NewTCPSession(s, conn); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testLimitConfig 初始化后的限流配置
+func testLimitConfig(t *testing.T, conf RateLimit) *ServiceConfig {
+	sc := &ServiceConfig{RateLimit: conf}
+	if err := sc.RateLimit.init(); err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+// statsDiff 限流处理次数的变化
+func statsDiff(before RateLimitStats) RateLimitStats {
+	after := RateLimitCounts()
+	return RateLimitStats{
+		Dropped:      after.Dropped - before.Dropped,
+		Warned:       after.Warned - before.Warned,
+		Disconnected: after.Disconnected - before.Disconnected,
+		Banned:       after.Banned - before.Banned,
+		Rejected:     after.Rejected - before.Rejected,
+	}
+}
+
+func TestBucket(t *testing.T) {
+	var b bucket
+	now := time.Now()
+	steps := []struct {
+		after time.Duration // 距离开始的时间
+		allow []bool        // 依次取令牌的结果
+	}{
+		{0, []bool{true, true, false}},                     // 开始时令牌桶是满的
+		{100 * time.Millisecond, []bool{true, false}},      // 每秒补充10个令牌
+		{150 * time.Millisecond, []bool{false}},            // 不足一个令牌
+		{time.Second, []bool{true, true, false}},           // 补充后不超过容量
+		{time.Second + 50*time.Millisecond, []bool{false}}, // 上次取令牌后重新计时
+		{time.Second + 100*time.Millisecond, []bool{true, false}},
+	}
+	for i, step := range steps {
+		for j, want := range step.allow {
+			if got := b.allow(10, 2, now.Add(step.after)); got != want {
+				t.Fatalf("step %d take %d: got %v, want %v", i, j, got, want)
+			}
+		}
+	}
+	if b.full(10, 2, now.Add(time.Second+200*time.Millisecond)) {
+		t.Fatal("bucket full before refill")
+	}
+	if !b.full(10, 2, now.Add(time.Second+300*time.Millisecond)) {
+		t.Fatal("bucket not full after refill")
+	}
+}
+
+func TestRateLimitMsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    RateLimit
+		msgs    []uint32 // 依次收到的消息号
+		allowed int
+		closed  CloseReason
+		diff    RateLimitStats
+	}{
+		{"drop", RateLimit{Rate: 1, Burst: 2}, []uint32{1, 1, 1, 1}, 2, CloseNone, RateLimitStats{Dropped: 2}},
+		{"warn", RateLimit{Rate: 1, Action: LimitWarn}, []uint32{1, 1, 1}, 3, CloseNone, RateLimitStats{Warned: 2}},
+		{"disconnect", RateLimit{Rate: 1, Action: LimitDisconnect}, []uint32{1, 1}, 1, CloseRateLimited, RateLimitStats{Disconnected: 1}},
+		{"ban", RateLimit{Rate: 1, Action: LimitBan}, []uint32{1, 1}, 1, CloseRateLimited, RateLimitStats{Disconnected: 1, Banned: 1}},
+		{"msg limit", RateLimit{Rate: 10, Msgs: []*MsgRateLimit{{MsgID: 2, Rate: 1}}}, []uint32{2, 1, 2, 1}, 3, CloseNone, RateLimitStats{Dropped: 1}},
+		{"no limit", RateLimit{}, []uint32{1, 1, 1}, 3, CloseNone, RateLimitStats{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testLimitSession(t, testLimitConfig(t, tt.conf))
+			r := newRateLimiter()
+			before := RateLimitCounts()
+			if !r.afterConnected(s.context) {
+				t.Fatal("connection rejected")
+			}
+			allowed := 0
+			for _, id := range tt.msgs {
+				if s.allow(&MsgHead{MsgID: id}) {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed || s.CloseReason() != tt.closed {
+				t.Fatalf("allowed %d closed %v, want %d %v", allowed, s.CloseReason(), tt.allowed, tt.closed)
+			}
+			if diff := statsDiff(before); diff != tt.diff {
+				t.Fatalf("stats %+v, want %+v", diff, tt.diff)
+			}
+			// 被封禁的IP不能再建立连接
+			if banned := !r.afterConnected(testLimitSession(t, s.SC).context); banned != (tt.conf.Action == LimitBan) {
+				t.Fatalf("banned %v", banned)
+			}
+		})
+	}
+}
+
+func TestRateLimitConn(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     RateLimit
+		accepted []bool // 依次建立连接的结果
+		diff     RateLimitStats
+	}{
+		{"drop", RateLimit{ConnRate: 1, ConnBurst: 2}, []bool{true, true, false, false}, RateLimitStats{Rejected: 2}},
+		{"warn", RateLimit{ConnRate: 1, Action: LimitWarn}, []bool{true, true, true}, RateLimitStats{Warned: 2}},
+		// 封禁后的连接不再消耗令牌，也不重复封禁
+		{"ban", RateLimit{ConnRate: 1, Action: LimitBan}, []bool{true, false, false}, RateLimitStats{Banned: 1, Rejected: 2}},
+		{"no limit", RateLimit{}, []bool{true, true, true}, RateLimitStats{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := testLimitConfig(t, tt.conf)
+			r := newRateLimiter()
+			before := RateLimitCounts()
+			for i, want := range tt.accepted {
+				if got := r.afterConnected(testLimitSession(t, sc).context); got != want {
+					t.Fatalf("conn %d: got %v, want %v", i, got, want)
+				}
+			}
+			if diff := statsDiff(before); diff != tt.diff {
+				t.Fatalf("stats %+v, want %+v", diff, tt.diff)
+			}
+		})
+	}
+}
+
+func TestRateLimitBanExpire(t *testing.T) {
+	sc := testLimitConfig(t, RateLimit{ConnRate: 1, Action: LimitBan, BanTime: 1})
+	r := newRateLimiter()
+	s := testLimitSession(t, sc)
+	ip := remoteIP(s)
+	r.afterConnected(s.context)
+	if r.afterConnected(testLimitSession(t, sc).context) {
+		t.Fatal("connection not limited")
+	}
+	if d := time.Until(r.bans[ip]); d <= 0 || d > sc.RateLimit.BanTime {
+		t.Fatalf("ban time %v", d)
+	}
+
+	// 封禁到期后令牌已经补充，可以重新建立连接
+	r.bans[ip] = time.Now().Add(-time.Millisecond)
+	r.ips[ip].last = time.Now().Add(-time.Second)
+	if !r.afterConnected(testLimitSession(t, sc).context) {
+		t.Fatal("connection rejected after ban expired")
+	}
+	if _, ok := r.bans[ip]; ok {
+		t.Fatal("expired ban not removed")
+	}
+
+	// 定时清理过期的封禁及已满的令牌桶
+	r.bans[ip] = time.Now().Add(-time.Millisecond)
+	r.ips[ip].last = time.Now().Add(-time.Second)
+	r.clean(&sc.RateLimit, time.Now().Add(time.Minute))
+	if len(r.bans) != 0 || len(r.ips) != 0 {
+		t.Fatalf("bans %d ips %d left", len(r.bans), len(r.ips))
+	}
+}
+
+func TestRateLimitForward(t *testing.T) {
+	for _, trusted := range []bool{false, true} {
+		s := NewSession(testLimitConfig(t, RateLimit{Rate: 1}))
+		s.SC.TrustForward = trusted
+		s.limit = &sessionLimit{r: newRateLimiter()}
+
+		allowed := 0
+		for i := 0; i < 3; i++ {
+			if s.allow(&MsgHead{MsgID: 1, Client: 1}) {
+				allowed++
+			}
+		}
+		// 只有可信网关链路上转发的消息不限流
+		if want := map[bool]int{false: 1, true: 3}[trusted]; allowed != want {
+			t.Errorf("trusted %v: allowed %d, want %d", trusted, allowed, want)
+		}
+	}
+}
+
+func TestRateLimitGateway(t *testing.T) {
+	testMsgHandler(t)
+	gateway := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 4, Type: 1, ID: 1},
+		Handler:    t.Name(),
+		Forward:    []*ForwardRule{{MinMsgID: 10, MaxMsgID: 19, Area: 4, Type: 3}},
+		PingMsgID:  100,
+		RateLimit:  RateLimit{Rate: 1, Burst: 3},
+	}
+	backend := &ServiceConfig{ServerInfo: ServerInfo{Area: 4, Type: 3, ID: 1}, IsClient: true}
+	for _, v := range []*ServiceConfig{gateway, backend} {
+		if err := v.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bs := NewSession(backend)
+	gRouter.Add(bs)
+	t.Cleanup(func() { gRouter.Remove(bs) })
+	gs := NewSession(gateway)
+	gs.limit = &sessionLimit{r: newRateLimiter()}
+
+	recv := func(msgID uint32) {
+		et, data, err := encodeMsg(&pipeMsg{Text: "flood"})
+		if msgID == gateway.PingMsgID {
+			et, data, err = encodeMsg(&Ping{Time: 1})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := gateway.codec.Marshal(&MsgHead{MsgID: msgID}, et, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gs.process(pkg)
+	}
+
+	// 网关在转发前限流
+	before := RateLimitCounts()
+	for i := 0; i < 10; i++ {
+		recv(10)
+	}
+	if len(bs.send) != 3 {
+		t.Fatalf("forwarded %d, want 3", len(bs.send))
+	}
+	// 心跳同样限流
+	recv(gateway.PingMsgID)
+	if len(gs.send) != 0 {
+		t.Fatal("ping answered after limit")
+	}
+	if diff := statsDiff(before); diff != (RateLimitStats{Dropped: 8}) {
+		t.Fatalf("stats %+v", diff)
+	}
+	bs.drainSend()
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRegistry 进程内服务注册中心
+// 同一个进程中的所有服务发现共用，用于测试或单进程部署
+type MemoryRegistry struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	endpoints map[ServerKey]*memoryEndpoint
+}
+
+type memoryEndpoint struct {
+	e        Endpoint
+	lastTime time.Time
+}
+
+func NewMemoryRegistry(ttl time.Duration) *MemoryRegistry {
+	return &MemoryRegistry{
+		ttl:       ttl,
+		endpoints: make(map[ServerKey]*memoryEndpoint),
+	}
+}
+
+func (m *MemoryRegistry) Register(e *Endpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.endpoints[e.Key()] = &memoryEndpoint{e: *e, lastTime: time.Now()}
+	return nil
+}
+
+func (m *MemoryRegistry) Deregister(e *Endpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.endpoints, e.Key())
+	return nil
+}
+
+func (m *MemoryRegistry) Endpoints() ([]*Endpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	ret := make([]*Endpoint, 0, len(m.endpoints))
+	for key, v := range m.endpoints {
+		if m.ttl > 0 && now.Sub(v.lastTime) > m.ttl {
+			delete(m.endpoints, key)
+			continue
+		}
+		e := v.e
+		ret = append(ret, &e)
+	}
+	return ret, nil
+}
+
+func (m *MemoryRegistry) Close() error {
+	return nil
+}
+
+// FileRegistry 基于共享目录的服务注册中心
+// 每个服务节点对应目录中的一个文件，文件修改时间作为服务节点的存活时间，
+// 适用于同一台机器或共享文件系统上的多个进程，不依赖其它服务
+type FileRegistry struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewFileRegistry(dir string, ttl time.Duration) (*FileRegistry, error) {
+	if dir == "" {
+		return nil, errors.New("file registry path required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileRegistry{
+		dir: dir,
+		ttl: ttl,
+	}, nil
+}
+
+func (f *FileRegistry) filename(e *Endpoint) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%d_%d_%d.json", e.Area, e.Type, e.ID))
+}
+
+func (f *FileRegistry) Register(e *Endpoint) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免其它进程读到不完整的文件
+	name := f.filename(e)
+	tmp := name + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (f *FileRegistry) Deregister(e *Endpoint) error {
+	err := os.Remove(f.filename(e))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileRegistry) Endpoints() ([]*Endpoint, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var ret []*Endpoint
+	for _, v := range entries {
+		if v.IsDir() || !strings.HasSuffix(v.Name(), ".json") {
+			continue
+		}
+		info, err := v.Info()
+		if err != nil {
+			continue
+		}
+		if f.ttl > 0 && now.Sub(info.ModTime()) > f.ttl {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, v.Name()))
+		if err != nil {
+			continue
+		}
+		e := new(Endpoint)
+		if err = json.Unmarshal(data, e); err != nil {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+func (f *FileRegistry) Close() error {
+	return nil
+}
+
+var gMemoryRegistry *MemoryRegistry
+
+func init() {
+	RegisterRegistry("memory", func(config *DiscoveryConfig) (Registry, error) {
+		if gMemoryRegistry == nil {
+			gMemoryRegistry = NewMemoryRegistry(config.TTL)
+		}
+		return gMemoryRegistry, nil
+	})
+	RegisterRegistry("file", func(config *DiscoveryConfig) (Registry, error) {
+		return NewFileRegistry(config.Path, config.TTL)
+	})
+}
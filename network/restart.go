@@ -0,0 +1,216 @@
+package network
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errServiceClosed = errors.New("service closed")
+	errConnLost      = errors.New("connection lost")
+)
+
+// RestartPolicy 服务重启策略，服务启动失败或者异常关闭后按指数退避延迟重启
+// 客户端拨号失败或者连接建立后没有稳定运行 ResetAfter 就断开时也按照这个策略延迟重连，第一次延迟为 ServiceConfig.ReconnectInterval，
+// 和服务重启使用同一个失败计数，超过 MaxRetries 后放弃重连并关闭客户端服务
+type RestartPolicy struct {
+	MinDelay   time.Duration // 第一次重启的延迟，单位秒，默认5秒
+	MaxDelay   time.Duration // 最大延迟，单位秒，默认60秒
+	Multiplier float64       // 每次失败后延迟的倍数，默认2
+	Jitter     float64       // 随机抖动比例，0到1，默认0.2
+	MaxRetries int           // 连续失败的最大重启次数，超过后放弃重启，0表示不限制
+	ResetAfter time.Duration // 服务稳定运行超过这个时间后清零失败次数，单位秒，默认60秒
+}
+
+func (r *RestartPolicy) init() {
+	if r.MinDelay <= 0 {
+		r.MinDelay = TimeRestart
+	} else {
+		r.MinDelay *= time.Second
+	}
+	if r.MaxDelay <= 0 {
+		r.MaxDelay = 60 * time.Second
+	} else {
+		r.MaxDelay *= time.Second
+	}
+	if r.MaxDelay < r.MinDelay {
+		r.MaxDelay = r.MinDelay
+	}
+	if r.Multiplier < 1 {
+		r.Multiplier = 2
+	}
+	if r.Jitter <= 0 || r.Jitter > 1 {
+		r.Jitter = 0.2
+	}
+	if r.ResetAfter <= 0 {
+		r.ResetAfter = 60 * time.Second
+	} else {
+		r.ResetAfter *= time.Second
+	}
+}
+
+// delay 计算第n次失败后的延迟
+// base 第一次的延迟
+func (r *RestartPolicy) delay(base time.Duration, n int) time.Duration {
+	d := float64(base) * math.Pow(r.Multiplier, float64(n-1))
+	if max := float64(r.MaxDelay); d > max || math.IsInf(d, 0) {
+		d = max
+	}
+	d += d * r.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(d)
+}
+
+// ServiceState 服务状态
+type ServiceState int
+
+const (
+	ServiceStarted    ServiceState = iota + 1 // 启动成功
+	ServiceFailed                             // 启动失败或者异常关闭
+	ServiceRestarting                         // 等待重启
+	ServiceGaveUp                             // 连续失败次数超过 RestartPolicy.MaxRetries，放弃重启
+	ServiceStopped                            // 正常关闭或者被移除
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case ServiceStarted:
+		return "started"
+	case ServiceFailed:
+		return "failed"
+	case ServiceRestarting:
+		return "restarting"
+	case ServiceGaveUp:
+		return "gave up"
+	case ServiceStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// ServiceEvent 服务状态变化事件
+type ServiceEvent struct {
+	Config   *ServiceConfig
+	State    ServiceState
+	Err      error         // 失败原因
+	Failures int           // 连续失败次数
+	Delay    time.Duration // 重启延迟，ServiceRestarting 时有效
+}
+
+var serviceHooks []func(e *ServiceEvent)
+
+// OnServiceState 注册服务状态变化的回调方法，用于监控
+// 回调方法在module节点上执行，需要在网络服务启动前注册
+func OnServiceState(f func(e *ServiceEvent)) {
+	serviceHooks = append(serviceHooks, f)
+}
+
+// serviceStatus 服务运行状态
+type serviceStatus struct {
+	failures int       // 连续失败次数
+	started  time.Time // 最后一次启动成功的时间
+}
+
+func (n *Network) status(config *ServiceConfig) *serviceStatus {
+	st, ok := n.statuses[config.Key()]
+	if !ok {
+		st = &serviceStatus{}
+		n.statuses[config.Key()] = st
+	}
+	return st
+}
+
+func (n *Network) fireState(e *ServiceEvent) {
+	for _, f := range serviceHooks {
+		f(e)
+	}
+}
+
+// started 服务启动成功
+func (n *Network) started(config *ServiceConfig) {
+	st := n.status(config)
+	st.started = time.Now()
+	n.fireState(&ServiceEvent{Config: config, State: ServiceStarted, Failures: st.failures})
+}
+
+// stopped 服务正常关闭或者被移除
+func (n *Network) stopped(config *ServiceConfig) {
+	delete(n.statuses, config.Key())
+	n.fireState(&ServiceEvent{Config: config, State: ServiceStopped})
+}
+
+// restart 服务启动失败或者异常关闭，按重启策略延迟重启
+func (n *Network) restart(config *ServiceConfig, err error) {
+	st := n.status(config)
+	if !st.started.IsZero() && time.Since(st.started) >= config.Restart.ResetAfter {
+		st.failures = 0
+	}
+	st.started = time.Time{}
+	st.failures++
+	n.fireState(&ServiceEvent{Config: config, State: ServiceFailed, Err: err, Failures: st.failures})
+
+	if config.Restart.MaxRetries > 0 && st.failures > config.Restart.MaxRetries {
+		log.WithField("ServiceInfo", config).Errorf("network service gave up after %d failures: %v", st.failures, err)
+		n.fireState(&ServiceEvent{Config: config, State: ServiceGaveUp, Err: err, Failures: st.failures})
+		return
+	}
+
+	delay := config.Restart.delay(config.Restart.MinDelay, st.failures)
+	log.WithField("ServiceInfo", config).Warningf("network service failed %d times: %v, restarting in %v",
+		st.failures, err, delay)
+	n.fireState(&ServiceEvent{Config: config, State: ServiceRestarting, Err: err, Failures: st.failures, Delay: delay})
+	time.AfterFunc(delay, func() {
+		n.NewService(config)
+	})
+}
+
+// reconnect 客户端拨号失败或者连接断开，和 restart 使用同一个失败计数
+// connected 断开的连接建立的时间，拨号失败时为零值，连接稳定运行超过 RestartPolicy.ResetAfter 时清零失败次数并立即重连
+// 返回重连延迟，连续失败次数超过 RestartPolicy.MaxRetries 时返回false，服务标记为移除，调用方关闭服务后不再重启
+func (n *Network) reconnect(config *ServiceConfig, err error, connected time.Time) (time.Duration, bool) {
+	if _, ok := n.removed[config.Key()]; ok || n.close {
+		// 服务正在关闭
+		return 0, true
+	}
+	st := n.status(config)
+	if !connected.IsZero() && time.Since(connected) >= config.Restart.ResetAfter {
+		st.failures = 0
+		return 0, true
+	}
+	st.failures++
+	n.fireState(&ServiceEvent{Config: config, State: ServiceFailed, Err: err, Failures: st.failures})
+
+	if config.Restart.MaxRetries > 0 && st.failures > config.Restart.MaxRetries {
+		log.WithField("ServiceInfo", config).Errorf("network client gave up after %d failures: %v", st.failures, err)
+		n.fireState(&ServiceEvent{Config: config, State: ServiceGaveUp, Err: err, Failures: st.failures})
+		n.removed[config.Key()] = struct{}{}
+		return 0, false
+	}
+
+	delay := config.Restart.delay(config.ReconnectInterval, st.failures)
+	log.WithField("ServiceInfo", config).Warningf("network client failed %d times: %v, reconnecting in %v",
+		st.failures, err, delay)
+	n.fireState(&ServiceEvent{Config: config, State: ServiceRestarting, Err: err, Failures: st.failures, Delay: delay})
+	return delay, true
+}
+
+// redial 延迟后触发一次拨号
+func redial(dialCh chan struct{}, delay time.Duration) {
+	if delay <= 0 {
+		select {
+		case dialCh <- struct{}{}:
+		default:
+			log.Panicln("bug")
+		}
+		return
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case dialCh <- struct{}{}:
+		default:
+		}
+	})
+}
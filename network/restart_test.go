@@ -0,0 +1,54 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientGiveUp(t *testing.T) {
+	client := &ServiceConfig{
+		ServerInfo:    ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:      "pipe",
+		Path:          t.Name(),
+		IsClient:      true,
+		ClientNum:     1,
+		AutoReconnect: true,
+		Restart:       RestartPolicy{MaxRetries: 2},
+	}
+	if err := client.init(); err != nil {
+		t.Fatal(err)
+	}
+	client.ReconnectInterval = time.Millisecond
+	client.Restart.MaxDelay = 10 * time.Millisecond
+
+	var states []ServiceState
+	OnServiceState(func(e *ServiceEvent) {
+		if e.Config == client {
+			states = append(states, e.State)
+		}
+	})
+
+	n := NewNetwork()
+	if n.newService(client) == nil {
+		t.Fatal("client start error")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(n.service) > 0 {
+		n.Update()
+		time.Sleep(time.Millisecond)
+	}
+
+	want := []ServiceState{ServiceStarted,
+		ServiceFailed, ServiceRestarting,
+		ServiceFailed, ServiceRestarting,
+		ServiceFailed, ServiceGaveUp,
+		ServiceStopped}
+	if len(states) != len(want) {
+		t.Fatalf("states: %v", states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("states: %v", states)
+		}
+	}
+}
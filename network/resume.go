@@ -0,0 +1,479 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultResumeGrace       = 30 * time.Second // 连接断开后默认保留连接状态的时间
+	DefaultResumeAckInterval = time.Second      // 默认发送确认消息的时间间隔
+)
+
+var ErrResumeFailed = errors.New("session resume failed")
+
+// ResumeConfig 连接恢复配置，连接断开后在 Grace 时间内保留连接状态，客户端重连后使用 Token 恢复
+// 恢复后双方的 Session 及 Context 不变，重发对端没有收到的消息
+// 服务端和客户端需要配置相同的 MsgID，启用时 SendPolicy 及 RecvPolicy 不能丢弃消息
+type ResumeConfig struct {
+	MsgID       uint32        // 连接恢复控制消息的消息号，消息结构为 Resume，为0时不启用
+	Grace       time.Duration // 连接断开后保留连接状态的时间，单位秒，默认30秒
+	MaxPending  int           // 保留的没有确认的消息数量，超过时丢弃最早的消息，丢弃后无法恢复，默认等于 MaxSend
+	AckInterval time.Duration // 发送确认消息的时间间隔，单位毫秒，默认1000毫秒
+}
+
+func (r *ResumeConfig) init(config *ServiceConfig) error {
+	if r.MsgID == 0 {
+		return nil
+	}
+	for _, v := range []string{config.SendPolicy, config.RecvPolicy} {
+		if v == PolicyDropOldest || v == PolicyDropNewest {
+			return errors.New("resume requires a queue policy that never drops messages")
+		}
+	}
+	if r.Grace > 0 {
+		r.Grace *= time.Second
+	} else {
+		r.Grace = DefaultResumeGrace
+	}
+	if r.MaxPending <= 0 {
+		r.MaxPending = config.MaxSend
+	}
+	if r.AckInterval > 0 {
+		r.AckInterval *= time.Millisecond
+	} else {
+		r.AckInterval = DefaultResumeAckInterval
+	}
+	return nil
+}
+
+// Resume 连接恢复控制消息，消息号为 ResumeConfig.MsgID
+//
+// 双方按顺序给除了 Resume 以外的每个数据包计数，分片消息的每一片都计数，服务端从发送 Token 之后开始计数
+// 1. 服务端建立连接后发送 Token，客户端保存 Token 及收到的数据包数量
+// 2. 双方定时发送 Ack 确认收到的数据包数量，服务端删除已经确认的数据包
+// 3. 客户端重连后第一个消息发送 Token 及 Ack，返回之前新连接上发送的消息暂存，收到的数据包丢弃，收到新连接的 Token 之后的数据包计数
+// 4. 恢复成功时返回服务端收到的数据包数量 Ack，然后重发客户端没有收到的数据包，客户端也重发服务端没有收到的数据包
+// 5. 恢复失败时返回 Err，新连接作为一个新的连接继续使用
+type Resume struct {
+	Token string
+	Ack   uint64 // 收到的数据包数量
+	Err   string
+}
+
+// sessionResume 连接恢复状态，只在module节点上访问
+type sessionResume struct {
+	token    string
+	sent     uint64      // 发送的数据包数量
+	recv     uint64      // 收到的数据包数量
+	acked    uint64      // 最后一次确认的数量
+	ackTime  time.Time   // 最后一次确认的时间
+	pending  []*sendPack // 没有确认的数据包，序号从 sent-len(pending)+1 开始
+	parked   bool        // 连接已经断开，等待恢复
+	deadline time.Time   // 等待恢复的期限
+	waiting  bool        // 新连接正在等待恢复 reqAck 对应的连接
+	reqAck   uint64      // 新连接请求恢复时客户端收到的数据包数量
+
+	old     *Session      // 客户端新连接请求恢复的连接
+	claimed bool          // 客户端已经有新连接在请求恢复这个连接
+	held    [][]*sendPack // 客户端新连接收到恢复结果之前发送的消息
+}
+
+// received 收到除了 Resume 以外的数据包时计数，返回false时丢弃数据包
+// 客户端收到 Token 之前服务端还没有开始计数，请求恢复的新连接收到结果之前丢弃收到的数据包
+func (r *sessionResume) received() bool {
+	if r.token != "" {
+		r.recv++
+	}
+	return r.old == nil
+}
+
+// track 记录发送的数据包，确认之前保留数据
+func (r *sessionResume) track(packs []*sendPack, max int) {
+	for _, p := range packs {
+		if p.ref == nil {
+			p.ref = new(int32)
+			*p.ref = 1
+		}
+		atomic.AddInt32(p.ref, 1)
+		r.pending = append(r.pending, p)
+	}
+	r.sent += uint64(len(packs))
+	if n := len(r.pending) - max; n > 0 {
+		r.drop(n)
+	}
+}
+
+// drop 删除最早的n个数据包
+func (r *sessionResume) drop(n int) {
+	for i := 0; i < n; i++ {
+		r.pending[i].release()
+		r.pending[i] = nil
+	}
+	r.pending = r.pending[n:]
+	if len(r.pending) == 0 {
+		r.pending = nil
+	}
+}
+
+// canResume 对端收到 ack 个数据包时，没有收到的数据包是否都还保留着
+func (r *sessionResume) canResume(ack uint64) bool {
+	return ack <= r.sent && r.sent-ack <= uint64(len(r.pending))
+}
+
+// ack 删除对端已经确认的数据包
+func (r *sessionResume) ack(ack uint64) {
+	if ack > r.sent {
+		ack = r.sent
+	}
+	first := r.sent - uint64(len(r.pending))
+	if ack > first {
+		r.drop(int(ack - first))
+	}
+}
+
+// resumeMgr 等待恢复的连接，只在module节点上访问
+type resumeMgr struct {
+	tokens  map[string]*Session
+	parked  map[*Session]struct{}
+	waiters map[*Session]*Session // 等待恢复的连接及请求恢复的新连接
+}
+
+var gResume = &resumeMgr{
+	tokens:  make(map[string]*Session),
+	parked:  make(map[*Session]struct{}),
+	waiters: make(map[*Session]*Session),
+}
+
+// parkedClient 查找客户端服务等待恢复，并且没有新连接正在请求恢复的连接
+func (m *resumeMgr) parkedClient(sc *ServiceConfig) *Session {
+	for s := range m.parked {
+		if s.SC == sc && !s.resume.claimed && atomic.LoadInt32(&s.final) == 0 {
+			return s
+		}
+	}
+	return nil
+}
+
+// finish 服务关闭后等待恢复的连接不再恢复，在下一次 update 时关闭
+func (m *resumeMgr) finish(sc *ServiceConfig) {
+	for s := range m.parked {
+		if s.SC == sc {
+			atomic.StoreInt32(&s.final, 1)
+		}
+	}
+}
+
+// update 恢复等待中的连接，关闭超过期限的连接
+func (m *resumeMgr) update(now time.Time) {
+	for s := range m.waiters {
+		m.try(s)
+	}
+	for s := range m.parked {
+		if atomic.LoadInt32(&s.final) != 0 || now.After(s.resume.deadline) {
+			delete(m.parked, s)
+			s.onClosed()
+		}
+	}
+}
+
+// request 新连接请求恢复
+func (m *resumeMgr) request(ns *Session, req *Resume) {
+	s := m.tokens[req.Token]
+	if s == nil || s == ns || s.SC != ns.SC || atomic.LoadInt32(&s.final) != 0 || !s.resume.canResume(req.Ack) {
+		log.WithField("SessionInfo", ns).Warning("resume failed: token invalid or messages lost")
+		ns.sendResume(&Resume{Err: ErrResumeFailed.Error()})
+		return
+	}
+	if w, ok := m.waiters[s]; ok && w != ns {
+		w.resume.waiting = false
+		w.sendResume(&Resume{Err: ErrResumeFailed.Error()})
+	}
+	ns.resume.waiting = true
+	ns.resume.reqAck = req.Ack
+	m.waiters[s] = ns
+	if !s.resume.parked {
+		// 旧连接还没有发现断开，关闭后再恢复
+		_ = s.CloseWithReason(ClosePeer)
+		return
+	}
+	m.try(s)
+}
+
+// try 旧连接的发送协程结束后开始恢复
+func (m *resumeMgr) try(s *Session) {
+	ns := m.waiters[s]
+	if ns.CloseReason() != CloseNone {
+		delete(m.waiters, s)
+		return
+	}
+	if !s.resume.parked {
+		return
+	}
+	select {
+	case <-s.sendDone:
+	default:
+		return
+	}
+	delete(m.waiters, s)
+	ns.resume.waiting = false
+	if atomic.LoadInt32(&s.final) != 0 || !s.resume.canResume(ns.resume.reqAck) {
+		if ns.SC.IsClient {
+			ns.resumeFailed(true)
+		} else {
+			ns.sendResume(&Resume{Err: ErrResumeFailed.Error()})
+		}
+		return
+	}
+	m.resume(s, ns)
+}
+
+// resume 新连接的连接实例及队列交给等待恢复的连接，新连接关闭
+func (m *resumeMgr) resume(s, ns *Session) {
+	held := ns.resume.held
+	ns.resume.old, ns.resume.held = nil, nil
+	s.resume.claimed = false
+	s.discardSend()
+	s.agent = ns.agent
+	s.send, s.recv, s.closeSign, s.sendDone = ns.send, ns.recv, ns.closeSign, ns.sendDone
+	ack := ns.resume.reqAck
+	ns.recvMu.Lock()
+	spill := ns.recvSpill
+	ns.recvSpill = nil
+	ns.recvMu.Unlock()
+	s.recvMu.Lock()
+	s.recvSpill = append(s.recvSpill, spill...)
+	s.recvMu.Unlock()
+	s.lastRecv = time.Now()
+	s.resume.parked = false
+	delete(m.parked, s)
+	atomic.StoreInt32((*int32)(&s.reason), int32(CloseNone))
+
+	// 新连接的读写协程之后操作恢复的连接
+	ns.setCloseReason(CloseResumed)
+	ns.owner.Store(s)
+	if ns.peers != nil {
+		delete(ns.peers, ns)
+		ns.peers[s] = struct{}{}
+		s.peers = ns.peers
+	}
+	ns.onClosed()
+
+	s.resume.ack(ack)
+	if !s.SC.IsClient {
+		s.sendResume(&Resume{Ack: s.resume.recv})
+	}
+	s.replay()
+	for _, v := range held {
+		_ = s.push(v...)
+	}
+	log.WithField("SessionInfo", s).Tracef("session resumed, resend %d", len(s.resume.pending))
+}
+
+// parkable 因为网络原因断开的连接可以恢复
+func parkable(reason CloseReason) bool {
+	switch reason {
+	case ClosePeer, CloseReadError, CloseReadTimeout, CloseWriteError, CloseWriteTimeout:
+		return true
+	}
+	return false
+}
+
+// startResume 建立连接后服务端发送恢复凭证，客户端有等待恢复的连接时请求恢复
+func (s *Session) startResume() {
+	if s.SC.Resume.MsgID == 0 {
+		return
+	}
+	if s.SC.IsClient {
+		s.resume = &sessionResume{ackTime: time.Now()}
+		old := gResume.parkedClient(s.SC)
+		if old == nil {
+			return
+		}
+		old.resume.claimed = true
+		s.resume.old = old
+		s.sendResume(&Resume{Token: old.resume.token, Ack: old.resume.recv})
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.WithField("SessionInfo", s).Errorf("resume token error: %v", err)
+		return
+	}
+	token := hex.EncodeToString(b)
+	s.resume = &sessionResume{token: token, ackTime: time.Now()}
+	gResume.tokens[token] = s
+	s.sendResume(&Resume{Token: token})
+}
+
+// resolve 连接恢复后新连接的读写协程操作恢复的连接
+func (s *Session) resolve() *Session {
+	if o := s.owner.Load(); o != nil {
+		return o
+	}
+	return s
+}
+
+// resuming 新连接正在恢复或者已经恢复，不再处理收到的消息
+func (s *Session) resuming() bool {
+	return s.owner.Load() != nil || s.resume != nil && s.resume.waiting
+}
+
+// park 连接因为网络原因断开后等待恢复，返回false时直接关闭连接
+func (s *Session) park() bool {
+	r := s.resume
+	if r == nil || r.token == "" || r.waiting || r.old != nil || atomic.LoadInt32(&s.final) != 0 || !parkable(s.CloseReason()) {
+		return false
+	}
+	r.parked = true
+	r.deadline = time.Now().Add(s.SC.Resume.Grace)
+	gResume.parked[s] = struct{}{}
+	// 处理已经收到的消息，发送的消息保存在 pending 中
+	for {
+		v, ok := s.popRecv()
+		if !ok {
+			break
+		}
+		s.process(v)
+	}
+	s.discardSend()
+	log.WithField("SessionInfo", s).Tracef("session parked: %v", s.CloseReason())
+	if _, ok := gResume.waiters[s]; ok {
+		gResume.try(s)
+	}
+	return true
+}
+
+// discardSend 丢弃发送队列中的数据包，没有确认的数据包保存在 pending 中
+func (s *Session) discardSend() {
+	s.drainSend()
+	releasePacks(s.sendSpill)
+	s.sendSpill = nil
+}
+
+// replay 重发没有确认的数据包
+func (s *Session) replay() {
+	for _, p := range s.resume.pending {
+		atomic.AddInt32(p.ref, 1)
+		q := &sendPack{data: p.data, ref: p.ref, more: p.more}
+		select {
+		case s.send <- q:
+		default:
+			s.sendSpill = append(s.sendSpill, q)
+		}
+	}
+}
+
+// onResume 收到连接恢复控制消息
+func (s *Session) onResume(msg *Resume) {
+	r := s.resume
+	switch {
+	case s.SC.IsClient && msg.Token != "":
+		// 服务端给新连接的凭证，请求恢复失败时使用
+		r.token = msg.Token
+	case s.SC.IsClient && r.old != nil:
+		s.resumed(msg)
+	case msg.Token == "":
+		r.ack(msg.Ack)
+	default:
+		gResume.request(s, msg)
+	}
+}
+
+// resumed 客户端新连接收到恢复结果，成功时等待旧连接的发送协程结束后交给旧连接
+func (s *Session) resumed(msg *Resume) {
+	r := s.resume
+	old := r.old
+	if msg.Err != "" || old.resume == nil || !old.resume.canResume(msg.Ack) {
+		log.WithField("SessionInfo", s).Warningf("resume failed: %s", msg.Err)
+		// 服务端恢复成功，但是客户端没有保留需要重发的数据包时关闭连接
+		s.resumeFailed(msg.Err == "")
+		return
+	}
+	r.reqAck = msg.Ack
+	r.waiting = true
+	gResume.waiters[old] = s
+	gResume.try(old)
+}
+
+// resumeFailed 客户端恢复失败，等待恢复的连接关闭，新连接作为新的连接继续使用
+// closeConn 服务端已经恢复了连接，双方的状态不一致，需要关闭新连接
+func (s *Session) resumeFailed(closeConn bool) {
+	r := s.resume
+	old, held := r.old, r.held
+	r.old, r.held, r.waiting = nil, nil, false
+	if old.resume != nil {
+		old.resume.claimed = false
+	}
+	atomic.StoreInt32(&old.final, 1)
+	for _, v := range held {
+		_ = s.push(v...)
+	}
+	if closeConn {
+		_ = s.CloseWithReason(CloseProtocol)
+	}
+}
+
+// sendResume 发送连接恢复控制消息，不计数
+func (s *Session) sendResume(msg *Resume) {
+	et, data, err := encodeMsg(msg)
+	if err != nil {
+		log.WithField("SessionInfo", s).Errorf("resume message error: %v", err)
+		return
+	}
+	pkgs, err := s.SC.pack(&MsgHead{MsgID: s.SC.Resume.MsgID}, et, data)
+	if err != nil {
+		log.WithField("SessionInfo", s).Errorf("resume message error: %v", err)
+		return
+	}
+	packs := make([]*sendPack, len(pkgs))
+	for i, v := range pkgs {
+		packs[i] = &sendPack{data: v, ctrl: true}
+	}
+	_ = s.push(packs...)
+}
+
+// ackResume 定时确认收到的数据包
+func (s *Session) ackResume(now time.Time) {
+	r := s.resume
+	if r == nil || r.recv == r.acked || now.Sub(r.ackTime) < s.SC.Resume.AckInterval {
+		return
+	}
+	r.acked, r.ackTime = r.recv, now
+	s.sendResume(&Resume{Ack: r.recv})
+}
+
+// releaseResume 连接关闭后释放没有确认的数据包
+func (s *Session) releaseResume() {
+	r := s.resume
+	if r == nil {
+		return
+	}
+	if gResume.tokens[r.token] == s {
+		delete(gResume.tokens, r.token)
+	}
+	if w, ok := gResume.waiters[s]; ok {
+		// 请求恢复的新连接不再等待
+		delete(gResume.waiters, s)
+		w.resume.waiting = false
+		if w.SC.IsClient {
+			w.resumeFailed(true)
+		} else {
+			w.sendResume(&Resume{Err: ErrResumeFailed.Error()})
+		}
+	}
+	delete(gResume.parked, s)
+	if r.old != nil && r.old.resume != nil {
+		r.old.resume.claimed = false
+	}
+	for _, v := range r.held {
+		releasePacks(v)
+	}
+	r.drop(len(r.pending))
+	s.resume = nil
+}
@@ -0,0 +1,209 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// resumeTest 启用连接恢复的管道服务端和客户端
+type resumeTest struct {
+	t      *testing.T
+	n1, n2 *Network
+	client *TCPClient
+	server *TCPServer
+	sRecv  []string // 服务端收到的消息
+	cRecv  []string // 客户端收到的消息
+}
+
+func newResumeTest(t *testing.T, maxPending int) *resumeTest {
+	r := &resumeTest{t: t}
+	sh, ch := testMsgHandler(t), GetMsgHandler(t.Name()+"/client")
+	t.Cleanup(func() { delete(gMsgHandlers, t.Name()+"/client") })
+	sh.SetHandlerFunc(1, new(pipeMsg), func(c *Context) {
+		r.sRecv = append(r.sRecv, c.Msg.(*pipeMsg).Text)
+	})
+	ch.SetHandlerFunc(1, new(pipeMsg), func(c *Context) {
+		r.cRecv = append(r.cRecv, c.Msg.(*pipeMsg).Text)
+	})
+
+	server := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 1, Type: 1, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		Handler:    t.Name(),
+		Resume:     ResumeConfig{MsgID: 100, MaxPending: maxPending, AckInterval: 1},
+	}
+	client := &ServiceConfig{
+		ServerInfo:    ServerInfo{Area: 1, Type: 2, ID: 1},
+		Protocol:      "pipe",
+		Path:          t.Name(),
+		Handler:       t.Name() + "/client",
+		IsClient:      true,
+		ClientNum:     1,
+		AutoReconnect: true,
+		Resume:        ResumeConfig{MsgID: 100, AckInterval: 1},
+	}
+	r.n1, r.n2, r.client = testPipe(t, server, client)
+	client.ReconnectInterval = time.Millisecond
+	r.server = r.n1.service[server.Key()].(*TCPServer)
+	return r
+}
+
+// wait 更新网络模块直到满足条件
+func (r *resumeTest) wait(what string, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			r.t.Fatalf("timeout waiting for %s", what)
+		}
+		r.n1.Update()
+		r.n2.Update()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connected 等待客户端收到恢复凭证，返回双方的连接
+func (r *resumeTest) connected() (ss, cs *Session) {
+	r.wait("connected", func() bool {
+		ss, cs = nil, nil
+		for s := range r.server.sessions {
+			ss = s
+		}
+		for s := range r.client.sessions {
+			cs = s
+		}
+		return ss != nil && cs != nil && cs.resume != nil && cs.resume.token != "" && cs.resume.old == nil
+	})
+	return
+}
+
+// disconnect 断开底层连接，等待双方都进入等待恢复状态
+func (r *resumeTest) disconnect(ss, cs *Session) {
+	_ = cs.agent.Close()
+	r.wait("parked", func() bool {
+		_, ok1 := gResume.parked[ss]
+		_, ok2 := gResume.parked[cs]
+		return ok1 && ok2
+	})
+}
+
+func TestResume(t *testing.T) {
+	r := newResumeTest(t, 0)
+	ss, cs := r.connected()
+	ss.Send(1, &pipeMsg{Text: "a"})
+	r.wait("a", func() bool { return len(r.cRecv) == 1 })
+
+	r.disconnect(ss, cs)
+	// 断开期间发送的消息在恢复后重发
+	ss.Send(1, &pipeMsg{Text: "b"})
+	ss.Send(1, &pipeMsg{Text: "c"})
+	cs.Send(1, &pipeMsg{Text: "x"})
+
+	r.wait("resumed", func() bool { return len(r.cRecv) == 3 && len(r.sRecv) == 1 })
+	if !reflect.DeepEqual(r.cRecv, []string{"a", "b", "c"}) || !reflect.DeepEqual(r.sRecv, []string{"x"}) {
+		t.Fatalf("client %v server %v", r.cRecv, r.sRecv)
+	}
+	// 恢复后双方的 Session 不变
+	ss2, cs2 := r.connected()
+	if ss2 != ss || cs2 != cs || ss.CloseReason() != CloseNone || cs.CloseReason() != CloseNone {
+		t.Fatalf("session replaced: %v %v", ss.CloseReason(), cs.CloseReason())
+	}
+	ss.Send(1, &pipeMsg{Text: "d"})
+	r.wait("d", func() bool { return len(r.cRecv) == 4 })
+}
+
+func TestResumeAck(t *testing.T) {
+	r := newResumeTest(t, 0)
+	ss, cs := r.connected()
+	for i := 0; i < 10; i++ {
+		ss.Send(1, &pipeMsg{Text: "s"})
+		cs.Send(1, &pipeMsg{Text: "c"})
+	}
+	r.wait("received", func() bool { return len(r.cRecv) == 10 && len(r.sRecv) == 10 })
+	// 对端确认后删除保留的数据包
+	r.wait("acked", func() bool { return len(ss.resume.pending) == 0 && len(cs.resume.pending) == 0 })
+	if ss.resume.sent != 10 || cs.resume.recv != 10 || cs.resume.sent != 10 || ss.resume.recv != 10 {
+		t.Fatalf("server sent %d recv %d, client sent %d recv %d",
+			ss.resume.sent, ss.resume.recv, cs.resume.sent, cs.resume.recv)
+	}
+}
+
+func TestResumeOverflow(t *testing.T) {
+	r := newResumeTest(t, 2)
+	ss, cs := r.connected()
+	r.disconnect(ss, cs)
+	// 超过 MaxPending 后最早的消息被丢弃，无法恢复
+	for i := 0; i < 5; i++ {
+		ss.Send(1, &pipeMsg{Text: "lost"})
+	}
+
+	var ns *Session
+	r.wait("new session", func() bool {
+		ns = nil
+		for s := range r.client.sessions {
+			ns = s
+		}
+		return ns != nil && ns != cs && cs.resume == nil && ns.resume != nil && ns.resume.old == nil && ns.resume.token != ""
+	})
+	if _, ok := gResume.parked[cs]; ok {
+		t.Fatal("client session still parked")
+	}
+	if len(r.cRecv) != 0 {
+		t.Fatalf("client received %v", r.cRecv)
+	}
+
+	// 新连接作为新的连接继续使用
+	ns.Send(1, &pipeMsg{Text: "new"})
+	r.wait("new", func() bool { return len(r.sRecv) == 1 })
+}
+
+func TestResumeKick(t *testing.T) {
+	r := newResumeTest(t, 0)
+	ss, cs := r.connected()
+	ss.Kick(KickDuplicateLogin, "")
+	r.wait("closed", func() bool { return ss.resume == nil })
+	if ss.CloseReason() != CloseKicked {
+		t.Fatalf("close reason: %v", ss.CloseReason())
+	}
+	if _, ok := gResume.parked[ss]; ok {
+		t.Fatal("kicked session parked")
+	}
+
+	// 客户端请求恢复失败，旧连接关闭
+	r.wait("new session", func() bool {
+		for s := range r.client.sessions {
+			return s != cs && cs.resume == nil && s.resume != nil && s.resume.old == nil
+		}
+		return false
+	})
+	for s := range r.server.sessions {
+		if s == ss {
+			t.Fatal("kicked session resumed")
+		}
+	}
+}
+
+func TestResumeCloseCounts(t *testing.T) {
+	r := newResumeTest(t, 0)
+	ss, cs := r.connected()
+	before := CloseCounts()
+
+	// 等待恢复的连接恢复后不统计断开的原因，只统计被替换的新连接
+	r.disconnect(ss, cs)
+	r.wait("resumed", func() bool {
+		_, ok1 := r.server.sessions[ss]
+		_, ok2 := r.client.sessions[cs]
+		return ok1 && ok2 && ss.CloseReason() == CloseNone && cs.CloseReason() == CloseNone
+	})
+	after := CloseCounts()
+	for c := CloseNormal; c < closeReasonMax; c++ {
+		var want int64
+		if c == CloseResumed {
+			want = 2
+		}
+		if got := after[c] - before[c]; got != want {
+			t.Errorf("%v counted %d, want %d", c, got, want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package network
+
+import (
+	"errors"
+	"net"
+)
+
+// listenReusePort 当前系统不支持 SO_REUSEPORT
+func listenReusePort(network, addr string) (net.Listener, error) {
+	return nil, errors.New("SO_REUSEPORT not supported")
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package network
+
+import "testing"
+
+func TestReusePort(t *testing.T) {
+	ln1, err := listenReusePort("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln1.Close()
+	ln2, err := listenReusePort("tcp", ln1.Addr().String())
+	if err != nil {
+		t.Fatalf("second listener on %v: %v", ln1.Addr(), err)
+	}
+	ln2.Close()
+}
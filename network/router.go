@@ -0,0 +1,259 @@
+package network
+
+import (
+	"errors"
+	"strconv"
+
+	"stathat.com/c/consistent"
+)
+
+var ErrServerNotFound = errors.New("server not found")
+
+// serverRoute 服务的所有连接
+type serverRoute struct {
+	info     ServerInfo
+	sessions []*Session
+	next     int
+}
+
+// typeRoute 同一地区同一类型的所有服务
+type typeRoute struct {
+	servers []ServerKey
+	next    int
+	hash    *consistent.Consistent
+}
+
+func typeKey(area, typ uint8) uint16 {
+	return uint16(area)<<8 | uint16(typ)
+}
+
+// Router 路由表
+// 记录所有tcp/websocket服务端和客户端的连接，根据 SessionKey 或 ServerKey 查找连接
+// 客户端的连接自动以服务配置中的服务标识作为对端服务标识，服务端的连接需要通过 Bind 设置对端服务标识
+// 线程不安全，必须在module节点上执行
+type Router struct {
+	sessions map[SessionKey]*Session
+	servers  map[ServerKey]*serverRoute
+	types    map[uint16]*typeRoute
+}
+
+func NewRouter() *Router {
+	return &Router{
+		sessions: make(map[SessionKey]*Session),
+		servers:  make(map[ServerKey]*serverRoute),
+		types:    make(map[uint16]*typeRoute),
+	}
+}
+
+// Add 添加连接
+func (r *Router) Add(s *Session) {
+	r.sessions[s.Key()] = s
+	if s.SC.IsClient {
+		r.Bind(s, &s.SC.ServerInfo)
+	}
+}
+
+// Remove 移除连接
+func (r *Router) Remove(s *Session) {
+	delete(r.sessions, s.Key())
+	r.unbind(s)
+}
+
+// Bind 设置连接的对端服务标识，之后可以通过对端服务标识查找连接
+// s 连接
+// info 对端服务标识
+func (r *Router) Bind(s *Session, info *ServerInfo) {
+	r.unbind(s)
+	s.server = info
+
+	key := info.Key()
+	sr, ok := r.servers[key]
+	if !ok {
+		sr = &serverRoute{info: *info}
+		r.servers[key] = sr
+
+		tk := typeKey(info.Area, info.Type)
+		tr, ok := r.types[tk]
+		if !ok {
+			tr = &typeRoute{hash: consistent.New()}
+			r.types[tk] = tr
+		}
+		tr.servers = append(tr.servers, key)
+		tr.hash.Add(strconv.FormatUint(uint64(key), 10))
+	}
+	sr.sessions = append(sr.sessions, s)
+}
+
+func (r *Router) unbind(s *Session) {
+	if s.server == nil {
+		return
+	}
+	key := s.server.Key()
+	s.server = nil
+
+	sr, ok := r.servers[key]
+	if !ok {
+		return
+	}
+	for i, v := range sr.sessions {
+		if v == s {
+			sr.sessions = append(sr.sessions[:i], sr.sessions[i+1:]...)
+			break
+		}
+	}
+	if len(sr.sessions) > 0 {
+		return
+	}
+
+	delete(r.servers, key)
+	tk := typeKey(sr.info.Area, sr.info.Type)
+	tr, ok := r.types[tk]
+	if !ok {
+		return
+	}
+	for i, v := range tr.servers {
+		if v == key {
+			tr.servers = append(tr.servers[:i], tr.servers[i+1:]...)
+			break
+		}
+	}
+	tr.hash.Remove(strconv.FormatUint(uint64(key), 10))
+	if len(tr.servers) == 0 {
+		delete(r.types, tk)
+	}
+}
+
+// Session 根据连接标识查找连接
+func (r *Router) Session(key SessionKey) *Session {
+	return r.sessions[key]
+}
+
+// Server 根据服务标识查找连接，同一个服务有多个连接时轮流使用
+func (r *Router) Server(key ServerKey) *Session {
+	sr, ok := r.servers[key]
+	if !ok || len(sr.sessions) == 0 {
+		return nil
+	}
+	sr.next = (sr.next + 1) % len(sr.sessions)
+	return sr.sessions[sr.next]
+}
+
+// Servers 获取同一地区同一类型的所有服务标识
+func (r *Router) Servers(area, typ uint8) []ServerKey {
+	tr, ok := r.types[typeKey(area, typ)]
+	if !ok {
+		return nil
+	}
+	return append([]ServerKey(nil), tr.servers...)
+}
+
+// RoundRobin 轮询选择同一地区同一类型的服务
+func (r *Router) RoundRobin(area, typ uint8) *Session {
+	tr, ok := r.types[typeKey(area, typ)]
+	if !ok || len(tr.servers) == 0 {
+		return nil
+	}
+	tr.next = (tr.next + 1) % len(tr.servers)
+	return r.Server(tr.servers[tr.next])
+}
+
+// Hash 根据一致性hash选择同一地区同一类型的服务，相同的key总是选择同一个服务
+func (r *Router) Hash(area, typ uint8, key string) *Session {
+	tr, ok := r.types[typeKey(area, typ)]
+	if !ok {
+		return nil
+	}
+	name, err := tr.hash.Get(key)
+	if err != nil {
+		return nil
+	}
+	sk, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		return nil
+	}
+	return r.Server(ServerKey(sk))
+}
+
+// SendTo 给指定服务发送消息
+func (r *Router) SendTo(key ServerKey, msgID uint32, msg interface{}) error {
+	s := r.Server(key)
+	if s == nil {
+		return ErrServerNotFound
+	}
+	return s.sendHead(&MsgHead{MsgID: msgID}, msg)
+}
+
+// SendToType 轮询选择同一地区同一类型的服务发送消息
+func (r *Router) SendToType(area, typ uint8, msgID uint32, msg interface{}) error {
+	s := r.RoundRobin(area, typ)
+	if s == nil {
+		return ErrServerNotFound
+	}
+	return s.sendHead(&MsgHead{MsgID: msgID}, msg)
+}
+
+// SendToHash 根据一致性hash选择同一地区同一类型的服务发送消息
+func (r *Router) SendToHash(area, typ uint8, key string, msgID uint32, msg interface{}) error {
+	s := r.Hash(area, typ, key)
+	if s == nil {
+		return ErrServerNotFound
+	}
+	return s.sendHead(&MsgHead{MsgID: msgID}, msg)
+}
+
+// BroadcastType 给同一地区同一类型的所有服务发送消息
+func (r *Router) BroadcastType(area, typ uint8, msgID uint32, msg interface{}) {
+	tr, ok := r.types[typeKey(area, typ)]
+	if !ok {
+		return
+	}
+	for _, v := range tr.servers {
+		if s := r.Server(v); s != nil {
+			s.sendHead(&MsgHead{MsgID: msgID}, msg)
+		}
+	}
+}
+
+var gRouter = NewRouter()
+
+// GetSession 根据连接标识查找连接
+// 线程不安全，必须在module节点上执行
+func GetSession(key SessionKey) *Session {
+	return gRouter.Session(key)
+}
+
+// GetServer 根据服务标识查找连接
+// 线程不安全，必须在module节点上执行
+func GetServer(key ServerKey) *Session {
+	return gRouter.Server(key)
+}
+
+// BindServer 设置服务端连接的对端服务标识，之后可以通过对端服务标识查找连接
+// 线程不安全，必须在module节点上执行
+func BindServer(s *Session, info *ServerInfo) {
+	gRouter.Bind(s, info)
+}
+
+// SendTo 给指定服务发送消息
+// 线程不安全，必须在module节点上执行
+func SendTo(key ServerKey, msgID uint32, msg interface{}) error {
+	return gRouter.SendTo(key, msgID, msg)
+}
+
+// SendToType 轮询选择同一地区同一类型的服务发送消息
+// 线程不安全，必须在module节点上执行
+func SendToType(area, typ uint8, msgID uint32, msg interface{}) error {
+	return gRouter.SendToType(area, typ, msgID, msg)
+}
+
+// SendToHash 根据一致性hash选择同一地区同一类型的服务发送消息，相同的key总是发送给同一个服务
+// 线程不安全，必须在module节点上执行
+func SendToHash(area, typ uint8, key string, msgID uint32, msg interface{}) error {
+	return gRouter.SendToHash(area, typ, key, msgID, msg)
+}
+
+// BroadcastType 给同一地区同一类型的所有服务发送消息
+// 线程不安全，必须在module节点上执行
+func BroadcastType(area, typ uint8, msgID uint32, msg interface{}) {
+	gRouter.BroadcastType(area, typ, msgID, msg)
+}
@@ -0,0 +1,189 @@
+package network
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	routeA = ServerInfo{Area: 1, Type: 1, ID: 1}
+	routeB = ServerInfo{Area: 1, Type: 1, ID: 2}
+	routeC = ServerInfo{Area: 1, Type: 2, ID: 1}
+)
+
+// testRouter 创建路由表，服务A有两个连接a1和a2，服务B和C各有一个连接b和c，服务A和B类型相同
+func testRouter() (*Router, map[*Session]string) {
+	a := &ServiceConfig{ServerInfo: routeA, IsClient: true}
+	names := map[*Session]string{
+		NewSession(a): "a1",
+		NewSession(a): "a2",
+		NewSession(&ServiceConfig{ServerInfo: routeB, IsClient: true}): "b",
+		NewSession(&ServiceConfig{ServerInfo: routeC, IsClient: true}): "c",
+	}
+	r := NewRouter()
+	for _, name := range []string{"a1", "a2", "b", "c"} {
+		for s, v := range names {
+			if v == name {
+				r.Add(s)
+			}
+		}
+	}
+	return r, names
+}
+
+func TestRouterSelect(t *testing.T) {
+	tests := []struct {
+		name string
+		pick func(r *Router) *Session
+		want []string // 依次选择的连接，空字符串表示没有找到
+	}{
+		{"server rotates sessions", func(r *Router) *Session { return r.Server(routeA.Key()) }, []string{"a2", "a1", "a2"}},
+		{"server not found", func(r *Router) *Session { return r.Server(ServerKey(0)) }, []string{""}},
+		{"round robin rotates servers", func(r *Router) *Session { return r.RoundRobin(1, 1) }, []string{"b", "a2", "b", "a1"}},
+		{"round robin single server", func(r *Router) *Session { return r.RoundRobin(1, 2) }, []string{"c", "c"}},
+		{"round robin type not found", func(r *Router) *Session { return r.RoundRobin(2, 1) }, []string{""}},
+		{"hash single server", func(r *Router) *Session { return r.Hash(1, 2, "user") }, []string{"c", "c"}},
+		{"hash type not found", func(r *Router) *Session { return r.Hash(2, 1, "user") }, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, names := testRouter()
+			for i, want := range tt.want {
+				if got := names[tt.pick(r)]; got != want {
+					t.Fatalf("pick %d: got %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterHash(t *testing.T) {
+	r, _ := testRouter()
+	picked := make(map[string]ServerKey)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		s := r.Hash(1, 1, key)
+		if s == nil {
+			t.Fatalf("hash %s not found", key)
+		}
+		picked[key] = s.Server().Key()
+		// 相同的key总是选择同一个服务
+		if again := r.Hash(1, 1, key); again.Server().Key() != picked[key] {
+			t.Fatalf("hash %s changed", key)
+		}
+	}
+
+	// 服务下线后只有原来选择这个服务的key重新选择
+	var moved int
+	for _, s := range r.sessions {
+		if s.Server().Key() == routeB.Key() {
+			r.Remove(s)
+		}
+	}
+	for key, sk := range picked {
+		got := r.Hash(1, 1, key).Server().Key()
+		if sk == routeA.Key() && got != sk {
+			t.Fatalf("hash %s moved from A", key)
+		}
+		if sk == routeB.Key() {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(picked) {
+		t.Fatalf("keys not distributed: %d of %d on B", moved, len(picked))
+	}
+}
+
+func TestRouterBind(t *testing.T) {
+	tests := []struct {
+		name  string
+		bind  []*ServerInfo // 依次绑定的对端服务标识
+		found ServerInfo    // 可以找到连接的服务标识
+		lost  []ServerInfo  // 找不到连接的服务标识
+	}{
+		{"bind", []*ServerInfo{&routeA}, routeA, []ServerInfo{routeB}},
+		{"rebind", []*ServerInfo{&routeA, &routeB}, routeB, []ServerInfo{routeA}},
+		{"rebind other type", []*ServerInfo{&routeA, &routeC}, routeC, []ServerInfo{routeA, routeB}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			s := NewSession(&ServiceConfig{ServerInfo: ServerInfo{Area: 2, Type: 1, ID: 1}})
+			r.Add(s)
+			if s.Server() != nil || r.Server(routeA.Key()) != nil {
+				t.Fatal("server session bound before Bind")
+			}
+			for _, info := range tt.bind {
+				r.Bind(s, info)
+			}
+			if r.Server(tt.found.Key()) != s || r.RoundRobin(tt.found.Area, tt.found.Type) != s {
+				t.Fatalf("%v not found", tt.found)
+			}
+			for _, info := range tt.lost {
+				if r.Server(info.Key()) != nil {
+					t.Fatalf("%v still bound", info)
+				}
+			}
+
+			// 移除连接后解除绑定，同类型没有其它服务时删除类型
+			r.Remove(s)
+			if r.Session(s.Key()) != nil || r.Server(tt.found.Key()) != nil || s.Server() != nil {
+				t.Fatal("session not removed")
+			}
+			if len(r.servers) != 0 || len(r.types) != 0 {
+				t.Fatalf("routes left: %d servers %d types", len(r.servers), len(r.types))
+			}
+		})
+	}
+}
+
+func TestRouterClose(t *testing.T) {
+	server := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 3, Type: 1, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+	}
+	client := &ServiceConfig{
+		ServerInfo: ServerInfo{Area: 3, Type: 2, ID: 1},
+		Protocol:   "pipe",
+		Path:       t.Name(),
+		IsClient:   true,
+		ClientNum:  1,
+	}
+	n1, n2, c := testPipe(t, server, client)
+
+	var ss, cs *Session
+	wait := func(what string, f func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !f() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s", what)
+			}
+			n1.Update()
+			n2.Update()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait("connected", func() bool {
+		for s := range c.sessions {
+			cs = s
+		}
+		for s := range n1.service[server.Key()].(*TCPServer).sessions {
+			ss = s
+		}
+		return cs != nil && ss != nil
+	})
+	peer := &ServerInfo{Area: 3, Type: 3, ID: 1}
+	BindServer(ss, peer)
+	if GetServer(client.Key()) != cs || GetSession(ss.Key()) != ss || GetServer(peer.Key()) != ss {
+		t.Fatal("sessions not routed")
+	}
+
+	// 连接关闭后从路由表中移除
+	_ = cs.agent.Close()
+	wait("closed", func() bool { return GetSession(cs.Key()) == nil && GetSession(ss.Key()) == nil })
+	if GetServer(client.Key()) != nil || GetServer(peer.Key()) != nil || gRouter.Servers(3, 2) != nil {
+		t.Fatal("closed session still routed")
+	}
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"errors"
+	"time"
+
+	"github.com/skeletongo/cube/module"
+	"github.com/skeletongo/cube/timer"
+)
+
+var (
+	ErrCallTimeout      = errors.New("rpc call timeout")
+	ErrSessionClosed    = errors.New("session closed")
+	ErrSendRejected     = errors.New("send rejected by filter")
+	ErrResponseRejected = errors.New("response rejected by filter")
+)
+
+// call 等待返回的rpc请求
+type call struct {
+	callback func(c *Context, err error)
+	timer    timer.Handle
+}
+
+// Call 发送rpc请求
+// msgID 消息号
+// req 请求消息数据
+// timeout 超时时长，小于等于0时不会超时，直到连接关闭
+// callback 收到返回消息、超时、返回消息被过滤器拒绝或连接关闭时在module节点上回调，返回消息在 c.Msg 中，
+// 返回消息的消息号需要通过 SetMessage 或 SetHandler 注册
+// 线程不安全，必须在module节点上执行
+func (s *Session) Call(msgID uint32, req interface{}, timeout time.Duration, callback func(c *Context, err error)) {
+	s.seq++
+	if s.seq == 0 {
+		s.seq++
+	}
+	seq := s.seq
+
+	if err := s.sendHead(&MsgHead{Flags: FlagRequest, MsgID: msgID, Seq: seq}, req); err != nil {
+		if callback != nil {
+			callback(s.context, err)
+		}
+		return
+	}
+
+	c := &call{callback: callback}
+	if timeout > 0 {
+		c.timer = timer.NewTimer(module.Obj, timeout, func() {
+			s.doCall(seq, ErrCallTimeout)
+		})
+	}
+	s.calls[seq] = c
+}
+
+// doCall 执行rpc回调
+func (s *Session) doCall(seq uint32, err error) {
+	c, ok := s.calls[seq]
+	if !ok {
+		return
+	}
+	delete(s.calls, seq)
+	if c.timer > 0 && !errors.Is(err, ErrCallTimeout) {
+		timer.Stop(c.timer)
+	}
+	if c.callback != nil {
+		c.callback(s.context, err)
+	}
+}
+
+// cancelCalls 连接关闭后结束所有等待返回的rpc请求
+func (s *Session) cancelCalls() {
+	for seq := range s.calls {
+		s.doCall(seq, ErrSessionClosed)
+	}
+}
+
+// Reply 返回rpc请求
+// msgID 返回消息的消息号
+// msg 返回消息数据
+// 只能在rpc请求的消息处理方法中调用，线程不安全，必须在module节点上执行
+func (c *Context) Reply(msgID uint32, msg interface{}) {
+	head := &MsgHead{Flags: FlagResponse, MsgID: msgID, Seq: c.Seq}
+	if c.Client != 0 {
+		// 回复网关转发的请求
+		head.Flags |= FlagForward
+		head.Client = c.Client
+	}
+	c.sendHead(head, msg)
+}
@@ -0,0 +1,108 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// rpcTest 服务端收到请求1后调用 reply，客户端收到消息3时调用 marker
+func rpcTest(t *testing.T, reply func(c *Context), marker func()) (*pipeTest, *Session, *Session) {
+	h := testMsgHandler(t)
+	h.SetHandlerFunc(1, new(pipeMsg), reply)
+	h.SetMessage(2, new(pipeMsg))
+	h.SetHandlerFunc(3, new(pipeMsg), func(c *Context) { marker() })
+	server, client := testConfigs(t)
+	p := newPipeTest(t, server, client)
+	ss, cs := p.connected()
+	return p, ss, cs
+}
+
+func TestCall(t *testing.T) {
+	p, _, cs := rpcTest(t, func(c *Context) {
+		c.Reply(2, &pipeMsg{Text: c.Msg.(*pipeMsg).Text + " pong"})
+	}, nil)
+
+	var reply string
+	var err error
+	done := 0
+	testOnModule(func() {
+		cs.Call(1, &pipeMsg{Text: "ping"}, time.Second, func(c *Context, e error) {
+			done++
+			err = e
+			if e == nil {
+				reply = c.Msg.(*pipeMsg).Text
+			}
+		})
+	})
+	p.wait("reply", func() bool { return done > 0 })
+	if done != 1 || err != nil || reply != "ping pong" {
+		t.Fatalf("done %d err %v reply %q", done, err, reply)
+	}
+	if len(cs.calls) != 0 {
+		t.Fatalf("calls left: %d", len(cs.calls))
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	testModule(t)
+	var seq uint32
+	var marked bool
+	p, ss, cs := rpcTest(t, func(c *Context) { seq = c.Seq }, func() { marked = true })
+
+	var errs []error
+	testOnModule(func() {
+		cs.Call(1, &pipeMsg{Text: "ping"}, 20*time.Millisecond, func(c *Context, e error) {
+			errs = append(errs, e)
+		})
+	})
+	p.wait("timeout", func() bool { return len(errs) > 0 })
+	if errs[0] != ErrCallTimeout || seq == 0 {
+		t.Fatalf("err %v seq %d", errs[0], seq)
+	}
+
+	// 超时后收到的返回消息被忽略
+	testOnModule(func() {
+		_ = ss.sendHead(&MsgHead{Flags: FlagResponse, MsgID: 2, Seq: seq}, &pipeMsg{Text: "late"})
+		ss.Send(3, &pipeMsg{})
+	})
+	p.wait("marker", func() bool { return marked })
+	if len(errs) != 1 || len(cs.calls) != 0 {
+		t.Fatalf("callbacks %d calls %d", len(errs), len(cs.calls))
+	}
+}
+
+func TestCallClose(t *testing.T) {
+	p, _, cs := rpcTest(t, func(c *Context) {}, nil)
+
+	var errs []error
+	testOnModule(func() {
+		cs.Call(1, &pipeMsg{Text: "ping"}, 0, func(c *Context, e error) {
+			errs = append(errs, e)
+		})
+		_ = cs.Close()
+	})
+	// 连接关闭后结束所有等待返回的rpc请求
+	p.wait("canceled", func() bool { return len(errs) > 0 })
+	if len(errs) != 1 || errs[0] != ErrSessionClosed || len(cs.calls) != 0 {
+		t.Fatalf("errs %v calls %d", errs, len(cs.calls))
+	}
+}
+
+func TestCallRejected(t *testing.T) {
+	p, _, cs := rpcTest(t, func(c *Context) {
+		c.Reply(2, &pipeMsg{Text: "pong"})
+	}, nil)
+	testFilter(cs.SC, &FilterFunc{BeforeReceived: func(c *Context) bool { return c.MsgID != 2 }})
+
+	var errs []error
+	testOnModule(func() {
+		cs.Call(1, &pipeMsg{Text: "ping"}, 0, func(c *Context, e error) {
+			errs = append(errs, e)
+		})
+	})
+	// 返回消息被过滤器拒绝时结束rpc请求，不等到连接关闭
+	p.wait("rejected", func() bool { return len(errs) > 0 })
+	if len(errs) != 1 || errs[0] != ErrResponseRejected || len(cs.calls) != 0 {
+		t.Fatalf("errs %v calls %d", errs, len(cs.calls))
+	}
+}
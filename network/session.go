@@ -7,10 +7,13 @@ import (
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/skeletongo/cube/base"
+	"github.com/skeletongo/cube/encoding"
 	"github.com/skeletongo/cube/module"
 )
 
@@ -35,6 +38,23 @@ type Agent interface {
 type sendPack struct {
 	data    []byte
 	msgType reflect.Type
+
+	// 分片发送时，最后一个分片记录完整的消息，用于 AfterSend
+	msgID uint32
+	et    encoding.EncodeType
+	body  []byte
+
+	more bool   // 后面还有同一个消息的分片
+	ref  *int32 // 多个连接共享同一份数据时的引用计数，见 Broadcast
+	ctrl bool   // 连接恢复控制消息，不计数，见 Resume
+}
+
+// release 数据包发送完成或丢弃后归还缓存，多个连接共享的数据在最后一个连接用完后归还
+func (p *sendPack) release() {
+	if p.ref != nil && atomic.AddInt32(p.ref, -1) > 0 {
+		return
+	}
+	putBuffer(bytes.NewBuffer(p.data))
 }
 
 // SessionKey 连接标识
@@ -56,6 +76,33 @@ type Session struct {
 	send      chan *sendPack // 消息发送队列
 	recv      chan []byte    // 消息接收队列
 	closeSign chan struct{}
+	seq       uint32                   // rpc请求序号
+	calls     map[uint32]*call         // 等待返回的rpc请求
+	server    *ServerInfo              // 对端服务标识
+	frags     map[SessionKey]*fragment // 分片重组缓存
+	fragLen   int                      // 分片重组缓存字节数
+	lastRecv  time.Time                // 最后一次收到消息的时间
+	created   time.Time                // 建立连接的时间
+	lastPing  time.Time                // 最后一次发送心跳的时间
+	rtt       time.Duration            // 心跳往返时间
+	reason    CloseReason              // 关闭原因
+	auth      sessionAuth              // 认证状态
+	limit     *sessionLimit            // 限流令牌桶，见 RateLimit
+	uid       uint64                   // 绑定的用户ID，见 BindUser
+	resume    *sessionResume           // 连接恢复状态，见 ResumeConfig
+	owner     atomic.Pointer[Session]  // 新连接恢复了旧连接后，读写协程操作旧连接
+	peers     map[*Session]struct{}    // 所属服务的连接集合，恢复连接时替换
+	sendDone  chan struct{}            // 发送协程结束
+	final     int32                    // 连接因为网络以外的原因关闭，不能恢复
+
+	sendSpill   []*sendPack // 发送溢出队列，见 PolicySpill
+	sendHigh    bool        // 发送溢出队列已经通知过高水位
+	sendDropped int64       // 发送队列已满时丢弃的数据包数量
+	flushing    bool        // 已经放入结束标记，见 closeAfterFlush
+	recvMu      sync.Mutex
+	recvSpill   [][]byte // 接收溢出队列，见 PolicySpill
+	recvHigh    bool     // 接收溢出队列已经通知过高水位
+	recvDropped int64    // 接收队列已满时丢弃的数据包数量
 }
 
 func NewSession(config *ServiceConfig) *Session {
@@ -65,6 +112,10 @@ func NewSession(config *ServiceConfig) *Session {
 		send:      make(chan *sendPack, config.MaxSend),
 		recv:      make(chan []byte, config.MaxRecv),
 		closeSign: make(chan struct{}),
+		sendDone:  make(chan struct{}),
+		calls:     make(map[uint32]*call),
+		lastRecv:  time.Now(),
+		created:   time.Now(),
 	}
 	s.context = &Context{
 		Session: s,
@@ -93,34 +144,59 @@ func (s *Session) RemoteAddr() net.Addr {
 // msgID 消息号
 // msg 消息数据
 // 线程不安全，必须在module节点上执行
-func (s *Session) Send(msgID uint16, msg interface{}) {
+func (s *Session) Send(msgID uint32, msg interface{}) {
+	s.sendHead(&MsgHead{MsgID: msgID}, msg)
+}
+
+func (s *Session) sendHead(head *MsgHead, msg interface{}) error {
 	// update context
-	s.context.MsgID = msgID
+	s.context.MsgID = head.MsgID
 	s.context.Msg = msg
 	if !s.fireBeforeSend() {
-		return
+		return ErrSendRejected
 	}
+	head.MsgID = s.context.MsgID
 
 	msgType := reflect.TypeOf(s.context.Msg)
 	if msgType == nil || msgType.Kind() != reflect.Ptr {
 		log.WithField("msgID", s.context.MsgID).Error("message pointer required")
-		return
+		return errors.New("message pointer required")
 	}
 
-	data, err := gMsgParser.Marshal(s.context.MsgID, s.context.Msg, int(Config.LenMsgLen))
-	if err != nil {
-		log.WithField("msgID", s.context.MsgID).Errorf("send message error: %v", err)
-		return
+	et, data, err := encodeMsg(s.context.Msg)
+	if err == nil {
+		var pkgs [][]byte
+		if pkgs, err = s.SC.pack(head, et, data); err == nil {
+			packs := make([]*sendPack, len(pkgs))
+			for i, v := range pkgs {
+				packs[i] = &sendPack{data: v}
+			}
+			last := packs[len(packs)-1]
+			last.msgType = msgType
+			if len(packs) > 1 {
+				last.msgID, last.et, last.body = head.MsgID, et, data
+			}
+			return s.push(packs...)
+		}
 	}
+	log.WithField("msgID", s.context.MsgID).Errorf("send message error: %v", err)
+	return err
+}
 
-	select {
-	case <-s.closeSign:
-		log.WithField("SessionInfo", s).Trace("session closed")
-	case s.send <- &sendPack{data: data, msgType: msgType}:
-	default:
-		log.WithField("SessionInfo", s).Error("close conn: channel full")
-		_ = s.Close()
+// Server 获取对端服务标识，客户端连接为服务配置中的服务标识，服务端连接需要通过 BindServer 设置
+func (s *Session) Server() *ServerInfo {
+	return s.server
+}
+
+// onConnected 建立连接后的初始化工作，在module节点上执行
+func (s *Session) onConnected() bool {
+	gRouter.Add(s)
+	s.context.initStates()
+	if !s.fireAfterConnected() {
+		return false
 	}
+	s.startResume()
+	return true
 }
 
 func (s *Session) fireAfterConnected() bool {
@@ -131,6 +207,19 @@ func (s *Session) fireAfterConnected() bool {
 	return true
 }
 
+// onClosed 连接关闭后的清理工作，在module节点上执行
+func (s *Session) onClosed() {
+	log.WithField("SessionInfo", s).Tracef("session closed: %v", s.CloseReason())
+	s.countClose()
+	gRouter.Remove(s)
+	s.cancelCalls()
+	s.releaseSpill()
+	s.fireAfterClosed()
+	s.leaveGroups()
+	gUsers.unbind(s)
+	s.releaseResume()
+}
+
 func (s *Session) fireAfterClosed() bool {
 	if !s.SC.filterChain.Fire(AfterClosed, s.context) {
 		return false
@@ -180,11 +269,24 @@ func (s *Session) fireErrorMsgID() bool {
 }
 
 func (s *Session) fireSendMsgAfterSend(pack *sendPack) {
-	if len(s.SC.filterChain.functions[AfterSend]) > 0 ||
-		len(s.SC.middleChain.functions[AfterSend]) > 0 {
+	s = s.resolve()
+	if pack.msgType != nil && (len(s.SC.filterChain.functions[AfterSend]) > 0 ||
+		len(s.SC.middleChain.functions[AfterSend]) > 0) {
 		msg := reflect.New(pack.msgType.Elem()).Interface()
-		msgID, err := gMsgParser.UnmarshalUnregister(pack.data, msg, int(Config.LenMsgLen))
-		putBuffer(bytes.NewBuffer(pack.data))
+		msgID := pack.msgID
+		var err error
+		if pack.body != nil {
+			err = unmarshalMsg(msgID, pack.et, pack.body, msg)
+		} else {
+			var head *MsgHead
+			var et encoding.EncodeType
+			var data []byte
+			if head, et, data, err = s.SC.codec.Unmarshal(pack.data); err == nil {
+				msgID = head.MsgID
+				err = unmarshalMsg(msgID, et, data, msg)
+			}
+		}
+		pack.release()
 		if err != nil {
 			log.Errorf("SendMsg UnmarshalUnregister error: %v", err)
 			return
@@ -196,12 +298,15 @@ func (s *Session) fireSendMsgAfterSend(pack *sendPack) {
 			s.fireAfterSend()
 		})
 	} else {
-		putBuffer(bytes.NewBuffer(pack.data))
+		pack.release()
 	}
 }
 
 func (s *Session) sendMsg() {
+	defer close(s.sendDone)
 	s.agent.SendMsg()
+	// 结束标记之后的数据包不会再发送
+	s.drainSend()
 }
 
 func (s *Session) readMsg() {
@@ -209,45 +314,164 @@ func (s *Session) readMsg() {
 }
 
 func (s *Session) do() {
+	now := time.Now()
+	defer s.keepalive(now)
+	s.flushSpill()
+	defer s.checkHighWater()
 	for i := 0; i < s.SC.MaxRecv; i++ {
-		select {
-		case v := <-s.recv:
-			msgID, msg, err := gMsgParser.Unmarshal(v, int(Config.LenMsgLen))
-			if err != nil {
-				var e *Error
-				if errors.As(err, &e) && e.IsType(ErrorTypeMsgID) {
-					// update context
-					s.context.MsgID = msgID
-					s.context.Packet = v[Config.LenMsgLen:]
-					s.fireErrorMsgID()
-					s.context.Packet = nil
-					//todo v是否要回收再利用
-				} else {
-					log.Errorf("message unmarshal error: %v", err)
-					putBuffer(bytes.NewBuffer(v))
-				}
-				continue
+		if s.resuming() {
+			return
+		}
+		v, ok := s.popRecv()
+		if !ok {
+			return
+		}
+		s.lastRecv = now
+		s.process(v)
+	}
+}
+
+// process 处理收到的消息
+func (s *Session) process(v []byte) {
+	head, et, data, err := s.SC.codec.Unmarshal(v)
+	if err != nil {
+		log.Errorf("message unmarshal error: %v", err)
+		putBuffer(bytes.NewBuffer(v))
+		return
+	}
+	if s.resume != nil {
+		if head.MsgID == s.SC.Resume.MsgID {
+			msg := new(Resume)
+			if err = unmarshalMsg(head.MsgID, et, data, msg); err != nil {
+				log.WithField("SessionInfo", s).Errorf("resume unmarshal error: %v", err)
+			} else {
+				s.onResume(msg)
 			}
 			putBuffer(bytes.NewBuffer(v))
+			return
+		}
+		if !s.resume.received() {
+			putBuffer(bytes.NewBuffer(v))
+			return
+		}
+	}
+	s.untrust(head)
+	if head.HasFlag(FlagFragment) {
+		data, err = s.reassemble(head, data)
+		putBuffer(bytes.NewBuffer(v))
+		if err != nil {
+			log.WithField("SessionInfo", s).Errorf("close conn: %v", err)
+			_ = s.CloseWithReason(CloseProtocol)
+			return
+		}
+		if data == nil {
+			return
+		}
+		v = nil
+	}
+	if !s.allow(head) {
+		putBuffer(bytes.NewBuffer(v))
+		return
+	}
+	if s.SC.PingMsgID != 0 && head.MsgID == s.SC.PingMsgID {
+		s.heartbeat(et, data)
+		putBuffer(bytes.NewBuffer(v))
+		return
+	}
+	if !s.authorized(head, et, data) {
+		putBuffer(bytes.NewBuffer(v))
+		return
+	}
+	if head.HasFlag(FlagForward) && s.SC.IsClient {
+		// 网关收到后端服务的消息，转发给客户端
+		s.relay(v, head, et, data)
+		putBuffer(bytes.NewBuffer(v))
+		return
+	}
 
-			// update context
-			s.context.MsgID = msgID
-			s.context.Msg = msg
-			if !s.fireBeforeReceived() {
-				continue
+	msg := s.SC.handler.CreateMessage(head.MsgID)
+	if msg == nil {
+		err = NewError(errors.New("msgID unregister"), ErrorTypeMsgID, head.MsgID)
+	} else {
+		// 对象池创建的消息处理完成后回收，包括被过滤器拒绝及rpc返回的消息
+		defer s.freeMessage(head.MsgID, msg)
+		err = unmarshalMsg(head.MsgID, et, data, msg)
+	}
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) && e.IsType(ErrorTypeMsgID) {
+			if head.HasFlag(FlagResponse) {
+				// 返回消息未注册
+				putBuffer(bytes.NewBuffer(v))
+				s.doCall(head.Seq, err)
+				return
 			}
-			h := GetHandler(s.context.MsgID)
-			if h != nil {
-				h.Process(s.context)
-				s.fireAfterReceived()
+			if s.forward(v, head, et, data) {
+				putBuffer(bytes.NewBuffer(v))
+				return
 			}
-		default:
-			return
+			// update context
+			s.context.MsgID = head.MsgID
+			s.context.Seq = head.Seq
+			s.context.Client = head.Client
+			s.context.UID = s.msgUID(head)
+			s.context.Packet = msgPacket(s.SC.codec, v)
+			s.context.et, s.context.data = et, data
+			s.fireErrorMsgID()
+			s.context.Packet = nil
+			s.context.data = nil
+			//todo v是否要回收再利用
+		} else {
+			log.Errorf("message unmarshal error: %v", err)
+			putBuffer(bytes.NewBuffer(v))
+		}
+		return
+	}
+	putBuffer(bytes.NewBuffer(v))
+
+	// update context
+	s.context.MsgID = head.MsgID
+	s.context.Msg = msg
+	s.context.Seq = head.Seq
+	s.context.Client = head.Client
+	s.context.UID = s.msgUID(head)
+	if !s.fireBeforeReceived() {
+		if head.HasFlag(FlagResponse) {
+			// 返回消息被过滤，结束等待返回的rpc请求
+			s.doCall(head.Seq, ErrResponseRejected)
 		}
+		return
+	}
+	if head.HasFlag(FlagResponse) {
+		s.doCall(head.Seq, nil)
+		s.fireAfterReceived()
+		return
+	}
+	h := s.SC.handler.GetHandler(s.context.MsgID)
+	if h != nil {
+		h.Process(s.context)
+		s.fireAfterReceived()
+	}
+}
+
+// freeMessage 消息放回对象池，上下文中不再保留消息的引用
+func (s *Session) freeMessage(msgID uint32, msg interface{}) {
+	if s.SC.handler.freeMessage(msgID, msg) && s.context.Msg == msg {
+		s.context.Msg = nil
 	}
 }
 
+// Close 关闭连接，关闭原因为 CloseNormal
 func (s *Session) Close() error {
+	return s.CloseWithReason(CloseNormal)
+}
+
+// CloseWithReason 关闭连接并记录关闭原因，重复关闭时只记录第一次的原因
+func (s *Session) CloseWithReason(reason CloseReason) error {
+	if o := s.owner.Load(); o != nil {
+		return o.CloseWithReason(reason)
+	}
+	s.setCloseReason(reason)
 	select {
 	case <-s.closeSign:
 		return nil
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"time"
@@ -11,50 +12,98 @@ import (
 type TCPClient struct {
 	network   *Network
 	SC        *ServiceConfig
+	connect   func(addr string) (net.Conn, error)
 	dialCh    chan struct{} // 触发拨号
 	sessions  map[*Session]struct{}
 	sessionCh chan *Session
 	connCh    chan net.Conn
+	failCh    chan error    // 拨号失败
 	closeSign chan struct{} // 触发服务关闭
 	dialSign  chan struct{} // 关闭拨号协程
+	tls       *tls.Config
 	close     bool
 }
 
 func NewTCPClient(n *Network, config *ServiceConfig) *TCPClient {
 	return &TCPClient{
-		network:   n,
-		SC:        config,
+		network: n,
+		SC:      config,
+		connect: func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		},
 		dialCh:    make(chan struct{}, config.ClientNum),
 		sessions:  make(map[*Session]struct{}, config.ClientNum),
 		sessionCh: make(chan *Session, config.ClientNum),
 		connCh:    make(chan net.Conn, config.ClientNum),
+		failCh:    make(chan error, config.ClientNum),
 		closeSign: make(chan struct{}),
 		dialSign:  make(chan struct{}),
 	}
 }
 
-func (t *TCPClient) dial(addr string) net.Conn {
-	for {
-		conn, err := net.Dial("tcp", addr)
-		select {
-		case <-t.dialSign:
-			if err == nil {
-				conn.Close()
-			}
-			return nil
-		default:
-			if err == nil {
-				return conn
-			}
+// dial 拨号一次，失败时由 Update 按重启策略延迟重试，服务关闭时返回nil
+func (t *TCPClient) dial(addr string) (net.Conn, error) {
+	conn, err := t.connect(addr)
+	if err == nil && t.tls != nil {
+		conn, err = t.handshake(conn)
+	}
+	select {
+	case <-t.dialSign:
+		if err == nil {
+			conn.Close()
 		}
-		log.WithField("ServiceInfo", t.SC).Warningf("tcp client dial to %v error: %v, retrying in %v",
-			err, addr, t.SC.ReconnectInterval)
-		time.Sleep(t.SC.ReconnectInterval)
+		return nil, nil
+	default:
+	}
+	if err != nil {
+		log.WithField("ServiceInfo", t.SC).Warningf("tcp client dial to %v error: %v", addr, err)
+	}
+	return conn, err
+}
+
+// redial 按重启策略延迟重连，连续失败次数过多时关闭服务
+// connected 断开的连接建立的时间，拨号失败时为零值
+func (t *TCPClient) redial(err error, connected time.Time) {
+	delay, ok := t.network.reconnect(t.SC, err, connected)
+	if !ok {
+		t.Shutdown()
+		return
+	}
+	redial(t.dialCh, delay)
+}
+
+// handshake TLS握手
+func (t *TCPClient) handshake(conn net.Conn) (net.Conn, error) {
+	c := tls.Client(conn, t.tls)
+	c.SetDeadline(time.Now().Add(t.SC.HandshakeTimeout))
+	if err := c.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// NewKCPClient 基于UDP的可靠传输客户端
+func NewKCPClient(n *Network, config *ServiceConfig) *TCPClient {
+	t := NewTCPClient(n, config)
+	opt := newKCPOption(config)
+	t.connect = func(addr string) (net.Conn, error) {
+		return dialKCP(addr, opt)
+	}
+	return t
 }
 
 func (t *TCPClient) Start() error {
 	addr := fmt.Sprintf("%s:%d", t.SC.Ip, t.SC.Port)
+	if t.SC.useTLS() {
+		config, err := t.SC.tlsConfig()
+		if err != nil {
+			log.WithField("ServiceInfo", t.SC).Errorf("tls error: %v", err)
+			return err
+		}
+		t.tls = config
+	}
 	for i := 0; i < t.SC.ClientNum; i++ {
 		t.dialCh <- struct{}{}
 	}
@@ -68,7 +117,11 @@ func (t *TCPClient) Start() error {
 			case <-t.dialSign:
 				return
 			case <-t.dialCh:
-				conn := t.dial(addr)
+				conn, err := t.dial(addr)
+				if err != nil {
+					t.failCh <- err
+					continue
+				}
 				if conn == nil {
 					continue
 				}
@@ -87,8 +140,11 @@ func (t *TCPClient) Update() {
 	for {
 		select {
 		case s := <-t.sessionCh:
-			s.fireAfterClosed()
+			s = s.resolve()
 			delete(t.sessions, s)
+			if t.close || !s.park() {
+				s.onClosed()
+			}
 			if t.close {
 				if len(t.sessions) == 0 {
 					t.network.Release(t.SC)
@@ -98,18 +154,20 @@ func (t *TCPClient) Update() {
 			}
 			// 断线重连
 			if s.SC.AutoReconnect {
-				select {
-				case t.dialCh <- struct{}{}:
-				default:
-					log.Panicln("bug")
-				}
+				t.redial(errConnLost, s.created)
+			}
+
+		case err := <-t.failCh:
+			if !t.close {
+				t.redial(err, time.Time{})
 			}
 
 		case <-t.closeSign:
 			t.closeSign = make(chan struct{})
 			t.close = true
+			gResume.finish(t.SC)
 			for v := range t.sessions {
-				v.Close()
+				v.CloseWithReason(CloseShutdown)
 			}
 		here:
 			for {
@@ -132,18 +190,20 @@ func (t *TCPClient) Update() {
 			if err != nil {
 				log.WithField("ServiceInfo", t.SC).Error("NewTCPSession error:", err)
 				conn.Close()
+				t.redial(err, time.Time{})
 				continue
 			}
 
 			t.sessions[s] = struct{}{}
+			s.peers = t.sessions
 			go s.sendMsg()
 			go func() {
 				s.readMsg()
 				t.sessionCh <- s
 			}()
 
-			if !s.fireAfterConnected() {
-				s.Close()
+			if !s.onConnected() {
+				s.CloseWithReason(CloseRejected)
 				continue
 			}
 
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -12,7 +13,10 @@ import (
 type TCPServer struct {
 	network   *Network
 	SC        *ServiceConfig
+	listen    func(addr string) (net.Listener, error)
 	ln        net.Listener
+	raw       net.Listener // 没有经过TLS包装的监听，见 Upgrade
+	drain     drain
 	sessions  map[*Session]struct{}
 	sessionCh chan *Session
 	connCh    chan net.Conn
@@ -22,24 +26,49 @@ type TCPServer struct {
 
 func NewTCPServer(network *Network, config *ServiceConfig) *TCPServer {
 	return &TCPServer{
-		network:   network,
-		SC:        config,
+		network: network,
+		SC:      config,
+		listen: func(addr string) (net.Listener, error) {
+			return listen(config, "tcp", addr)
+		},
 		sessions:  make(map[*Session]struct{}),
 		sessionCh: make(chan *Session, 1000),
 		connCh:    make(chan net.Conn, 1000),
 		closeSign: make(chan struct{}),
+		drain:     drain{sc: config},
 	}
 }
 
+// NewKCPServer 基于UDP的可靠传输服务端
+func NewKCPServer(network *Network, config *ServiceConfig) *TCPServer {
+	t := NewTCPServer(network, config)
+	opt := newKCPOption(config)
+	t.listen = func(addr string) (net.Listener, error) {
+		return listenKCP(addr, opt)
+	}
+	return t
+}
+
 func (t *TCPServer) Start() error {
 	addr := fmt.Sprintf("%s:%d", t.SC.Ip, t.SC.Port)
-	ln, err := net.Listen("tcp", addr)
+	ln, err := t.listen(addr)
 	if err != nil {
 		log.WithField("ServiceInfo", t.SC).Errorf("tcp server start error: %v", err)
 		return err
 	}
 	log.WithField("ServiceInfo", t.SC).Trace("tcp server start")
 
+	t.raw = ln
+	if t.SC.useTLS() {
+		config, err := t.SC.tlsConfig()
+		if err != nil {
+			ln.Close()
+			log.WithField("ServiceInfo", t.SC).Errorf("tls error: %v", err)
+			return err
+		}
+		ln = tls.NewListener(ln, config)
+	}
+
 	t.ln = ln
 
 	go func() {
@@ -82,8 +111,11 @@ func (t *TCPServer) Update() {
 	for {
 		select {
 		case s := <-t.sessionCh:
-			s.fireAfterClosed()
+			s = s.resolve()
 			delete(t.sessions, s)
+			if t.close || !s.park() {
+				s.onClosed()
+			}
 			if t.close && len(t.sessions) == 0 {
 				t.network.Release(t.SC)
 				return
@@ -92,9 +124,8 @@ func (t *TCPServer) Update() {
 		case <-t.closeSign:
 			t.closeSign = make(chan struct{})
 			t.close = true
-			for v := range t.sessions {
-				v.Close()
-			}
+			gResume.finish(t.SC)
+			t.drain.start(t.sessions)
 		here:
 			for {
 				select {
@@ -126,14 +157,15 @@ func (t *TCPServer) Update() {
 			}
 
 			t.sessions[s] = struct{}{}
+			s.peers = t.sessions
 			go s.sendMsg()
 			go func() {
 				s.readMsg()
 				t.sessionCh <- s
 			}()
 
-			if !s.fireAfterConnected() {
-				s.Close()
+			if !s.onConnected() {
+				s.CloseWithReason(CloseRejected)
 				continue
 			}
 
@@ -141,11 +173,16 @@ func (t *TCPServer) Update() {
 			for v := range t.sessions {
 				v.do()
 			}
+			t.drain.update(t.sessions)
 			return
 		}
 	}
 }
 
+func (t *TCPServer) listener() net.Listener {
+	return t.raw
+}
+
 func (t *TCPServer) Shutdown() {
 	log.WithField("ServiceInfo", t.SC).Trace("tcp server shutdown")
 	t.ln.Close()
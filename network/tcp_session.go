@@ -1,7 +1,7 @@
 package network
 
 import (
-	"bytes"
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -14,37 +14,55 @@ type TCPSession struct {
 }
 
 func NewTCPSession(s *Session, conn net.Conn) (*TCPSession, error) {
-	t := &TCPSession{
+	if err := setTCPOption(s.SC, conn); err != nil {
+		return nil, err
+	}
+	if s.SC.cipher != nil {
+		if s.SC.IsClient {
+			conn = s.SC.cipher.Client(conn)
+		} else {
+			conn = s.SC.cipher.Server(conn)
+		}
+	}
+	return &TCPSession{
 		Conn:    conn,
 		Session: s,
-	}
+	}, nil
+}
 
-	var err error
-	c := conn.(*net.TCPConn)
-	if s.SC.Linger > 0 {
-		if err = c.SetLinger(s.SC.Linger); err != nil {
-			return nil, err
+// setTCPOption 设置tcp连接参数，不是tcp连接时忽略
+func setTCPOption(sc *ServiceConfig, conn net.Conn) (err error) {
+	if c, ok := conn.(*tls.Conn); ok {
+		conn = c.NetConn()
+	}
+	c, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if sc.Linger > 0 {
+		if err = c.SetLinger(sc.Linger); err != nil {
+			return err
 		}
 	}
-	if err = c.SetKeepAlive(s.SC.KeepAlive); err != nil {
-		return nil, err
+	if err = c.SetKeepAlive(sc.KeepAlive); err != nil {
+		return err
 	}
-	if s.SC.KeepAlive && s.SC.KeepAlivePeriod > 0 {
-		if err = c.SetKeepAlivePeriod(s.SC.KeepAlivePeriod); err != nil {
-			return nil, err
+	if sc.KeepAlive && sc.KeepAlivePeriod > 0 {
+		if err = c.SetKeepAlivePeriod(sc.KeepAlivePeriod); err != nil {
+			return err
 		}
 	}
-	if s.SC.ReadBufferSize > 0 {
-		if err = c.SetReadBuffer(s.SC.ReadBufferSize); err != nil {
-			return nil, err
+	if sc.ReadBufferSize > 0 {
+		if err = c.SetReadBuffer(sc.ReadBufferSize); err != nil {
+			return err
 		}
 	}
-	if s.SC.WriteBufferSize > 0 {
-		if err = c.SetWriteBuffer(s.SC.WriteBufferSize); err != nil {
-			return nil, err
+	if sc.WriteBufferSize > 0 {
+		if err = c.SetWriteBuffer(sc.WriteBufferSize); err != nil {
+			return err
 		}
 	}
-	return t, err
+	return nil
 }
 
 func (t *TCPSession) SendMsg() {
@@ -57,11 +75,12 @@ func (t *TCPSession) SendMsg() {
 		if t.Session.SC.WriteTimeout > 0 {
 			t.Conn.SetWriteDeadline(time.Now().Add(t.Session.SC.WriteTimeout))
 		}
-		err := gPkgParser.EncodeByWriter(t.Conn, v.data)
+		err := t.Session.SC.codec.Write(t.Conn, v.data)
 		t.Conn.SetWriteDeadline(zero)
 		if err != nil {
-			log.Warningf("TCP EncodeByWriter error: %v", err)
-			putBuffer(bytes.NewBuffer(v.data))
+			log.Warningf("TCP write error: %v", err)
+			v.release()
+			t.Session.CloseWithReason(writeCloseReason(err))
 			break
 		}
 
@@ -73,19 +92,34 @@ func (t *TCPSession) SendMsg() {
 }
 
 func (t *TCPSession) ReadMsg() {
+	if c, ok := t.Conn.(*tls.Conn); ok && !t.Session.SC.IsClient {
+		// 服务端TLS握手，只设置读取期限，发送协程会设置自己的写入期限
+		c.SetReadDeadline(time.Now().Add(t.Session.SC.HandshakeTimeout))
+		err := c.Handshake()
+		c.SetReadDeadline(time.Time{})
+		if err != nil {
+			log.Warningf("tls handshake error: %v", err)
+			t.Session.CloseWithReason(CloseReadError)
+			return
+		}
+	}
+
 	var zero time.Time
 	for {
 		if t.Session.SC.ReadTimeout > 0 {
 			t.Conn.SetReadDeadline(time.Now().Add(t.Session.SC.ReadTimeout))
 		}
-		data, err := gPkgParser.DecodeByReader(t.Conn)
+		data, err := t.Session.SC.codec.Read(t.Conn)
 		t.Conn.SetReadDeadline(zero)
 		if err != nil {
-			log.Warningf("TCP DecodeByReader error: %v", err)
+			log.Warningf("TCP read error: %v", err)
+			t.Session.CloseWithReason(readCloseReason(err))
 			break
 		}
 
-		t.Session.recv <- data
+		if !t.Session.pushRecv(data) {
+			break
+		}
 	}
 
 	t.Session.Close()
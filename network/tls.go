@@ -0,0 +1,68 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// useTLS 是否启用TLS
+// 服务端配置了证书时启用；客户端配置了 TLS、证书或CA证书时启用
+func (sc *ServiceConfig) useTLS() bool {
+	if sc.IsClient {
+		return sc.TLS || sc.CertFile != "" || sc.CAFile != ""
+	}
+	return sc.CertFile != "" || sc.KeyFile != ""
+}
+
+// tlsConfig 根据服务配置创建TLS配置
+func (sc *ServiceConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if sc.CertFile != "" || sc.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(sc.CertFile, sc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	var pool *x509.CertPool
+	if sc.CAFile != "" {
+		data, err := os.ReadFile(sc.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in CAFile: %s", sc.CAFile)
+		}
+	}
+
+	if sc.IsClient {
+		config.RootCAs = pool
+		config.ServerName = sc.ServerName
+		if config.ServerName == "" {
+			config.ServerName = sc.Ip
+		}
+		config.InsecureSkipVerify = sc.InsecureSkipVerify
+		return config, nil
+	}
+
+	if len(config.Certificates) == 0 {
+		return nil, errors.New("tls server requires CertFile and KeyFile")
+	}
+	// 双向认证
+	if sc.ClientAuth {
+		if pool == nil {
+			return nil, errors.New("tls client auth requires CAFile")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
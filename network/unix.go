@@ -0,0 +1,44 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// unix域套接字，用于同一台机器上的服务之间通信，ServiceConfig.Path 为套接字文件路径
+
+func listenUnix(path string) (net.Listener, error) {
+	// 删除上次没有正常关闭时遗留的套接字文件，其它进程正在监听时不删除
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket already in use: %s", path)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(path)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// NewUnixServer unix域套接字服务端
+func NewUnixServer(network *Network, config *ServiceConfig) *TCPServer {
+	t := NewTCPServer(network, config)
+	t.listen = func(string) (net.Listener, error) {
+		return listen(config, "unix", config.Path)
+	}
+	return t
+}
+
+// NewUnixClient unix域套接字客户端
+func NewUnixClient(n *Network, config *ServiceConfig) *TCPClient {
+	t := NewTCPClient(n, config)
+	t.connect = func(string) (net.Conn, error) {
+		return net.Dial("unix", config.Path)
+	}
+	return t
+}
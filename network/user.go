@@ -0,0 +1,136 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 同一个用户重复登录时的处理策略，见 ServiceConfig.DuplicateLogin
+const (
+	DuplicateKick   = "kick"   // 踢掉旧连接
+	DuplicateReject = "reject" // 拒绝新连接
+)
+
+var ErrDuplicateLogin = errors.New("user already logged in")
+
+// KickReason 踢下线原因
+type KickReason int32
+
+const (
+	KickDuplicateLogin KickReason = iota + 1 // 在其它地方登录
+	KickLoginRejected                        // 已经在其它地方登录，拒绝本次登录
+)
+
+// Kick 踢下线通知，消息号为 ServiceConfig.KickMsgID
+type Kick struct {
+	Reason KickReason
+	Text   string
+}
+
+func checkDuplicateLogin(policy string) error {
+	switch policy {
+	case DuplicateKick, DuplicateReject:
+		return nil
+	}
+	return fmt.Errorf("duplicate login policy %s not found", policy)
+}
+
+// userMgr 用户ID和连接的绑定关系，只在module节点上访问
+type userMgr struct {
+	users map[uint64]*Session
+}
+
+var gUsers = &userMgr{users: make(map[uint64]*Session)}
+
+func (u *userMgr) bind(s *Session, uid uint64) error {
+	if s.uid == uid {
+		return nil
+	}
+	if old, ok := u.users[uid]; ok {
+		switch s.SC.DuplicateLogin {
+		case DuplicateReject:
+			log.WithField("SessionInfo", s).Warningf("reject duplicate login: %d", uid)
+			s.Kick(KickLoginRejected, ErrDuplicateLogin.Error())
+			return ErrDuplicateLogin
+		default:
+			log.WithField("SessionInfo", old).Warningf("kick duplicate login: %d", uid)
+			u.unbind(old)
+			old.Kick(KickDuplicateLogin, "")
+		}
+	}
+	u.unbind(s)
+	s.uid = uid
+	u.users[uid] = s
+	return nil
+}
+
+func (u *userMgr) unbind(s *Session) {
+	if s.uid == 0 {
+		return
+	}
+	if u.users[s.uid] == s {
+		delete(u.users, s.uid)
+	}
+	s.uid = 0
+}
+
+// BindUser 绑定用户ID和连接，连接关闭后自动解除绑定
+// 用户已经绑定了其它连接时按新连接的 ServiceConfig.DuplicateLogin 处理，拒绝新连接时返回 ErrDuplicateLogin
+// 网关绑定用户后，转发给后端服务的消息携带用户ID，见 Context.UID
+// 线程不安全，必须在module节点上执行
+func BindUser(s *Session, uid uint64) error {
+	if uid == 0 {
+		return errors.New("uid required")
+	}
+	return gUsers.bind(s, uid)
+}
+
+// UnbindUser 解除连接绑定的用户ID
+// 线程不安全，必须在module节点上执行
+func UnbindUser(s *Session) {
+	gUsers.unbind(s)
+}
+
+// SessionByUser 根据用户ID查找连接，没有绑定时返回nil
+// 线程不安全，必须在module节点上执行
+func SessionByUser(uid uint64) *Session {
+	return gUsers.users[uid]
+}
+
+// UID 获取连接绑定的用户ID，没有绑定时为0
+func (s *Session) UID() uint64 {
+	return s.uid
+}
+
+// msgUID 消息所属的用户ID，只有可信的网关链路上转发的消息使用消息头中的用户ID
+func (s *Session) msgUID(head *MsgHead) uint64 {
+	if head.HasFlag(FlagForward) && s.trusted() {
+		return head.UID
+	}
+	return s.uid
+}
+
+// Kick 通知对端被踢下线，发送完队列中的消息后关闭连接
+// reason 踢下线原因
+// text 原因说明
+// 线程不安全，必须在module节点上执行
+func (s *Session) Kick(reason KickReason, text string) {
+	// 等待恢复的连接不再恢复
+	atomic.StoreInt32(&s.final, 1)
+	if s.SC.KickMsgID != 0 {
+		_ = s.sendHead(&MsgHead{MsgID: s.SC.KickMsgID}, &Kick{Reason: reason, Text: text})
+	}
+	s.closeAfterFlush(CloseKicked)
+}
+
+// ForwardUser 后端服务通过网关给用户发送消息，网关根据用户ID查找连接
+// uid 用户ID，见 BindUser
+// msgID 消息号
+// msg 消息数据
+// 线程不安全，必须在module节点上执行
+func (s *Session) ForwardUser(uid uint64, msgID uint32, msg interface{}) error {
+	return s.sendHead(&MsgHead{Flags: FlagForward | FlagUser, MsgID: msgID, UID: uid}, msg)
+}
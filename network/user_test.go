@@ -0,0 +1,150 @@
+package network
+
+import (
+	"testing"
+)
+
+// testUsers 测试结束后删除服务的连接绑定的用户，没有更新网络模块时关闭的连接不会解除绑定
+func testUsers(t *testing.T, sc *ServiceConfig) {
+	t.Cleanup(func() {
+		for uid, s := range gUsers.users {
+			if s.SC == sc {
+				delete(gUsers.users, uid)
+			}
+		}
+	})
+}
+
+// userTest 两个客户端连接的管道服务端，记录服务端发送的踢下线通知
+type userTest struct {
+	*pipeTest
+	kicks map[*Session]*Kick
+}
+
+func newUserTest(t *testing.T, policy string) (u *userTest, s1, s2 *Session) {
+	testMsgHandler(t)
+	server, client := testConfigs(t)
+	server.DuplicateLogin, server.KickMsgID = policy, 60
+	client.ClientNum = 2
+	u = &userTest{pipeTest: newPipeTest(t, server, client), kicks: make(map[*Session]*Kick)}
+	testUsers(t, server)
+	testFilter(server, &FilterFunc{BeforeSend: func(c *Context) bool {
+		if k, ok := c.Msg.(*Kick); ok {
+			u.kicks[c.Session] = k
+		}
+		return true
+	}})
+	u.wait("connected", func() bool { return len(u.server.sessions) == 2 && len(u.client.sessions) == 2 })
+	for s := range u.server.sessions {
+		if s1 == nil {
+			s1 = s
+		} else {
+			s2 = s
+		}
+	}
+	return
+}
+
+// closed 等待连接关闭
+func (u *userTest) closed(s *Session) {
+	u.wait("closed", func() bool {
+		_, ok := u.server.sessions[s]
+		return !ok
+	})
+}
+
+func TestBindUserDuplicate(t *testing.T) {
+	tests := []struct {
+		policy string
+		err    error
+		kick   KickReason
+	}{
+		{DuplicateKick, nil, KickDuplicateLogin},
+		{DuplicateReject, ErrDuplicateLogin, KickLoginRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			u, s1, s2 := newUserTest(t, tt.policy)
+			var err error
+			testOnModule(func() {
+				if err = BindUser(s1, 9); err != nil {
+					return
+				}
+				err = BindUser(s2, 9)
+			})
+			if err != tt.err {
+				t.Fatalf("bind: %v, want %v", err, tt.err)
+			}
+
+			// 踢掉旧连接时新连接保留，拒绝新连接时旧连接保留
+			survivor, kicked := s2, s1
+			if tt.policy == DuplicateReject {
+				survivor, kicked = s1, s2
+			}
+			if k := u.kicks[kicked]; k == nil || k.Reason != tt.kick || len(u.kicks) != 1 {
+				t.Fatalf("kicks %v", u.kicks)
+			}
+			u.closed(kicked)
+			if kicked.CloseReason() != CloseKicked || kicked.UID() != 0 {
+				t.Fatalf("kicked closed %v uid %d", kicked.CloseReason(), kicked.UID())
+			}
+			// 被踢掉的连接关闭后不影响保留的连接的绑定
+			if SessionByUser(9) != survivor || survivor.UID() != 9 || survivor.CloseReason() != CloseNone {
+				t.Fatalf("survivor uid %d closed %v", survivor.UID(), survivor.CloseReason())
+			}
+		})
+	}
+}
+
+func TestKick(t *testing.T) {
+	u, s1, s2 := newUserTest(t, DuplicateKick)
+	testOnModule(func() {
+		_ = BindUser(s1, 9)
+		_ = BindUser(s2, 10)
+		s1.Kick(KickDuplicateLogin, "bye")
+	})
+	if k := u.kicks[s1]; k == nil || k.Reason != KickDuplicateLogin || k.Text != "bye" {
+		t.Fatalf("kick %+v", k)
+	}
+	u.closed(s1)
+
+	// 连接关闭后自动解除绑定
+	if s1.CloseReason() != CloseKicked || SessionByUser(9) != nil || s1.UID() != 0 {
+		t.Fatalf("closed %v uid %d", s1.CloseReason(), s1.UID())
+	}
+	if SessionByUser(10) != s2 {
+		t.Fatal("other user unbound")
+	}
+	_ = s2.Close()
+	u.closed(s2)
+	if SessionByUser(10) != nil {
+		t.Fatal("closed session still bound")
+	}
+}
+
+func TestBindUser(t *testing.T) {
+	sc, _ := testConfigs(t)
+	if err := sc.init(); err != nil {
+		t.Fatal(err)
+	}
+	testUsers(t, sc)
+	s := NewSession(sc)
+	if err := BindUser(s, 0); err == nil {
+		t.Fatal("uid 0 bound")
+	}
+
+	// 重新绑定其它用户ID时解除原来的绑定，重复绑定没有影响
+	for _, uid := range []uint64{9, 9, 10} {
+		if err := BindUser(s, uid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if SessionByUser(9) != nil || SessionByUser(10) != s || s.UID() != 10 {
+		t.Fatalf("uid %d", s.UID())
+	}
+
+	UnbindUser(s)
+	if SessionByUser(10) != nil || s.UID() != 0 {
+		t.Fatal("not unbound")
+	}
+}
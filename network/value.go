@@ -0,0 +1,89 @@
+package network
+
+import "sync/atomic"
+
+var sessionValueSeq int32
+
+// sessionStates 建立连接后需要创建的连接状态，见 NewSessionState
+var sessionStates []func(c *Context)
+
+// SessionValue 类型安全的连接数据，代替 Context.Keys
+// 数据按创建时分配的下标保存在连接中，读写时不需要加锁，类型在编译时检查
+// 一般定义为包级变量，线程不安全，必须在module节点上使用
+type SessionValue[T any] struct {
+	name  string
+	index int
+	init  func() T
+}
+
+// NewSessionValue 创建连接数据，需要在网络服务启动前创建
+// name 名称，用于日志
+func NewSessionValue[T any](name string) *SessionValue[T] {
+	return &SessionValue[T]{
+		name:  name,
+		index: int(atomic.AddInt32(&sessionValueSeq, 1) - 1),
+	}
+}
+
+// NewSessionState 创建连接状态结构体，每个连接建立后在 AfterConnected 之前创建一个零值实例
+// 过滤器、中间件及消息处理方法中直接通过 Value 获取，连接恢复后状态不变，见 ResumeConfig
+// name 名称，用于日志
+func NewSessionState[T any](name string) *SessionValue[*T] {
+	v := NewSessionValue[*T](name)
+	v.init = func() *T { return new(T) }
+	sessionStates = append(sessionStates, func(c *Context) {
+		v.Set(c, v.init())
+	})
+	return v
+}
+
+func (v *SessionValue[T]) Name() string {
+	return v.name
+}
+
+// Get 获取连接数据，没有设置时返回零值及false
+func (v *SessionValue[T]) Get(c *Context) (value T, exists bool) {
+	if v.index < len(c.values) && c.values[v.index] != nil {
+		value, exists = c.values[v.index].(T)
+	}
+	return
+}
+
+// Value 获取连接数据，没有设置时返回零值，NewSessionState 创建的状态没有设置时创建并保存
+func (v *SessionValue[T]) Value(c *Context) T {
+	value, ok := v.Get(c)
+	if !ok && v.init != nil {
+		value = v.init()
+		v.Set(c, value)
+	}
+	return value
+}
+
+// Set 设置连接数据
+func (v *SessionValue[T]) Set(c *Context, value T) {
+	if v.index >= len(c.values) {
+		values := make([]interface{}, int(atomic.LoadInt32(&sessionValueSeq)))
+		copy(values, c.values)
+		c.values = values
+	}
+	c.values[v.index] = value
+}
+
+// Delete 删除连接数据
+func (v *SessionValue[T]) Delete(c *Context) {
+	if v.index < len(c.values) {
+		c.values[v.index] = nil
+	}
+}
+
+// initStates 建立连接后创建连接状态
+func (c *Context) initStates() {
+	for _, f := range sessionStates {
+		f(c)
+	}
+}
+
+// Context 获取连接的消息上下文，用于在消息处理方法以外访问连接数据，见 SessionValue
+func (s *Session) Context() *Context {
+	return s.context
+}
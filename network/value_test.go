@@ -0,0 +1,113 @@
+package network
+
+import (
+	"testing"
+)
+
+type testState struct {
+	Count int
+}
+
+var (
+	testIntValue    = NewSessionValue[int]("test.int")
+	testStringValue = NewSessionValue[string]("test.string")
+	testStateValue  = NewSessionState[testState]("test.state")
+)
+
+func TestSessionValue(t *testing.T) {
+	c1, c2 := NewSession(&ServiceConfig{}).Context(), NewSession(&ServiceConfig{}).Context()
+
+	// 没有设置时返回零值
+	if v, ok := testIntValue.Get(c1); ok || v != 0 {
+		t.Fatalf("missing: %v %v", v, ok)
+	}
+	if v := testStringValue.Value(c1); v != "" {
+		t.Fatalf("missing value: %q", v)
+	}
+
+	testIntValue.Set(c1, 7)
+	testStringValue.Set(c1, "a")
+	testIntValue.Set(c2, 0)
+	if v, ok := testIntValue.Get(c1); !ok || v != 7 {
+		t.Fatalf("int: %v %v", v, ok)
+	}
+	if v, ok := testStringValue.Get(c1); !ok || v != "a" {
+		t.Fatalf("string: %v %v", v, ok)
+	}
+	// 设置为零值时仍然存在，每个连接分别保存
+	if v, ok := testIntValue.Get(c2); !ok || v != 0 {
+		t.Fatalf("zero: %v %v", v, ok)
+	}
+	if _, ok := testStringValue.Get(c2); ok {
+		t.Fatal("value shared between sessions")
+	}
+
+	testIntValue.Delete(c1)
+	if _, ok := testIntValue.Get(c1); ok {
+		t.Fatal("value not deleted")
+	}
+	if v, _ := testStringValue.Get(c1); v != "a" {
+		t.Fatal("other value deleted")
+	}
+	// 删除没有设置过的数据没有影响
+	testStringValue.Delete(NewSession(&ServiceConfig{}).Context())
+}
+
+func TestSessionValueLate(t *testing.T) {
+	c := NewSession(&ServiceConfig{}).Context()
+	testIntValue.Set(c, 1)
+
+	// 连接保存数据之后创建的数据
+	late := NewSessionValue[[]byte]("test.late")
+	if _, ok := late.Get(c); ok {
+		t.Fatal("late value exists")
+	}
+	late.Set(c, []byte("late"))
+	if v, ok := late.Get(c); !ok || string(v) != "late" {
+		t.Fatalf("late: %q %v", v, ok)
+	}
+	if v, _ := testIntValue.Get(c); v != 1 {
+		t.Fatal("value lost after grow")
+	}
+}
+
+func TestSessionValueMismatch(t *testing.T) {
+	c := NewSession(&ServiceConfig{}).Context()
+	testIntValue.Set(c, 1)
+
+	// 保存的数据类型不一致时按没有设置处理
+	c.values[testIntValue.index] = "1"
+	if v, ok := testIntValue.Get(c); ok || v != 0 {
+		t.Fatalf("mismatch: %v %v", v, ok)
+	}
+	if v := testIntValue.Value(c); v != 0 {
+		t.Fatalf("mismatch value: %v", v)
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	// 建立连接后创建零值实例
+	c := NewSession(&ServiceConfig{}).Context()
+	c.initStates()
+	st, ok := testStateValue.Get(c)
+	if !ok || st == nil || st.Count != 0 {
+		t.Fatalf("state: %v %v", st, ok)
+	}
+	st.Count++
+	if testStateValue.Value(c).Count != 1 {
+		t.Fatal("state not shared")
+	}
+
+	// 没有创建时 Value 创建并保存
+	c = NewSession(&ServiceConfig{}).Context()
+	st = testStateValue.Value(c)
+	if st == nil {
+		t.Fatal("state not created")
+	}
+	if testStateValue.Value(c) != st {
+		t.Fatal("state created twice")
+	}
+	if testIntValue.Value(c) != 0 {
+		t.Fatal("value created without init")
+	}
+}
@@ -16,6 +16,7 @@ type WSClient struct {
 	sessions  map[*Session]struct{}
 	sessionCh chan *Session
 	connCh    chan *websocket.Conn
+	failCh    chan error    // 拨号失败
 	closeSign chan struct{} // 触发服务关闭
 	dialSign  chan struct{} // 关闭拨号协程
 	close     bool
@@ -34,33 +35,50 @@ func NewWSClient(n *Network, config *ServiceConfig) *WSClient {
 		sessions:  make(map[*Session]struct{}, config.ClientNum),
 		sessionCh: make(chan *Session, config.ClientNum),
 		connCh:    make(chan *websocket.Conn, config.ClientNum),
+		failCh:    make(chan error, config.ClientNum),
 		closeSign: make(chan struct{}),
 		dialSign:  make(chan struct{}),
 	}
 }
 
-func (w *WSClient) dial(url string) *websocket.Conn {
-	for {
-		conn, _, err := w.dialer.Dial(url, nil)
-		select {
-		case <-w.dialSign:
-			if err == nil {
-				conn.Close()
-			}
-			return nil
-		default:
-			if err == nil {
-				return conn
-			}
+// dial 拨号一次，失败时由 Update 按重启策略延迟重试，服务关闭时返回nil
+func (w *WSClient) dial(url string) (*websocket.Conn, error) {
+	conn, _, err := w.dialer.Dial(url, nil)
+	select {
+	case <-w.dialSign:
+		if err == nil {
+			conn.Close()
 		}
-		log.WithField("ServiceInfo", w.SC).Warningf("websocket connect to %v error: %v, retrying in %v",
-			url, err, w.SC.ReconnectInterval)
-		time.Sleep(w.SC.ReconnectInterval)
+		return nil, nil
+	default:
 	}
+	if err != nil {
+		log.WithField("ServiceInfo", w.SC).Warningf("websocket connect to %v error: %v", url, err)
+	}
+	return conn, err
+}
+
+// redial 按重启策略延迟重连，连续失败次数过多时关闭服务
+// connected 断开的连接建立的时间，拨号失败时为零值
+func (w *WSClient) redial(err error, connected time.Time) {
+	delay, ok := w.network.reconnect(w.SC, err, connected)
+	if !ok {
+		w.Shutdown()
+		return
+	}
+	redial(w.dialCh, delay)
 }
 
 func (w *WSClient) Start() error {
 	urlStr := w.SC.Protocol + "://" + w.SC.Ip + ":" + strconv.Itoa(int(w.SC.Port)) + w.SC.Path
+	if w.SC.useTLS() {
+		config, err := w.SC.tlsConfig()
+		if err != nil {
+			log.WithField("ServiceInfo", w.SC).Errorf("tls error: %v", err)
+			return err
+		}
+		w.dialer.TLSClientConfig = config
+	}
 	for i := 0; i < w.SC.ClientNum; i++ {
 		w.dialCh <- struct{}{}
 	}
@@ -74,7 +92,11 @@ func (w *WSClient) Start() error {
 			case <-w.dialSign:
 				return
 			case <-w.dialCh:
-				conn := w.dial(urlStr)
+				conn, err := w.dial(urlStr)
+				if err != nil {
+					w.failCh <- err
+					continue
+				}
 				if conn == nil {
 					continue
 				}
@@ -93,8 +115,11 @@ func (w *WSClient) Update() {
 	for {
 		select {
 		case s := <-w.sessionCh:
-			s.fireAfterClosed()
+			s = s.resolve()
 			delete(w.sessions, s)
+			if w.close || !s.park() {
+				s.onClosed()
+			}
 			if w.close {
 				if len(w.sessions) == 0 {
 					w.network.Release(w.SC)
@@ -104,18 +129,20 @@ func (w *WSClient) Update() {
 			}
 			// 断线重连
 			if s.SC.AutoReconnect {
-				select {
-				case w.dialCh <- struct{}{}:
-				default:
-					log.Panicln("bug")
-				}
+				w.redial(errConnLost, s.created)
+			}
+
+		case err := <-w.failCh:
+			if !w.close {
+				w.redial(err, time.Time{})
 			}
 
 		case <-w.closeSign:
 			w.closeSign = make(chan struct{})
 			w.close = true
+			gResume.finish(w.SC)
 			for v := range w.sessions {
-				v.Close()
+				v.CloseWithReason(CloseShutdown)
 			}
 		here:
 			for {
@@ -138,18 +165,20 @@ func (w *WSClient) Update() {
 			if err != nil {
 				log.WithField("ServiceInfo", w.SC).Errorf("NewWSSession error: %v", err)
 				conn.Close()
+				w.redial(err, time.Time{})
 				continue
 			}
 
 			w.sessions[s] = struct{}{}
+			s.peers = w.sessions
 			go s.sendMsg()
 			go func() {
 				s.readMsg()
 				w.sessionCh <- s
 			}()
 
-			if !s.fireAfterConnected() {
-				s.Close()
+			if !s.onConnected() {
+				s.CloseWithReason(CloseRejected)
 				continue
 			}
 
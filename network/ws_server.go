@@ -15,6 +15,8 @@ type WSServer struct {
 	SC        *ServiceConfig
 	server    *http.Server
 	ln        net.Listener
+	raw       net.Listener // 没有经过TLS包装的监听，见 Upgrade
+	drain     drain
 	upgrader  websocket.Upgrader
 	sessions  map[*Session]struct{}
 	sessionCh chan *Session
@@ -27,6 +29,7 @@ func NewWSServer(network *Network, config *ServiceConfig) *WSServer {
 	return &WSServer{
 		network: network,
 		SC:      config,
+		drain:   drain{sc: config},
 		upgrader: websocket.Upgrader{
 			HandshakeTimeout: config.HTTPTimeout,
 			CheckOrigin:      func(_ *http.Request) bool { return true },
@@ -60,24 +63,22 @@ func (w *WSServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 
 func (w *WSServer) Start() error {
 	addr := fmt.Sprintf("%s:%d", w.SC.Ip, w.SC.Port)
-	ln, err := net.Listen("tcp", addr)
+	ln, err := listen(w.SC, "tcp", addr)
 	if err != nil {
 		log.WithField("ServiceInfo", w.SC).Errorf("websocket server start error: %v", err)
 		return err
 	}
 	log.WithField("ServiceInfo", w.SC).Trace("websocket server start")
 
-	if w.SC.CertFile != "" || w.SC.KeyFile != "" {
-		config := &tls.Config{}
-		config.NextProtos = []string{"http/1.1"}
-
-		var err error
-		config.Certificates = make([]tls.Certificate, 1)
-		config.Certificates[0], err = tls.LoadX509KeyPair(w.SC.CertFile, w.SC.KeyFile)
+	w.raw = ln
+	if w.SC.useTLS() {
+		config, err := w.SC.tlsConfig()
 		if err != nil {
+			ln.Close()
 			log.WithField("ServiceInfo", w.SC).Errorf("tls error: %v", err)
 			return err
 		}
+		config.NextProtos = []string{"http/1.1"}
 
 		ln = tls.NewListener(ln, config)
 	}
@@ -107,8 +108,11 @@ func (w *WSServer) Update() {
 	for {
 		select {
 		case s := <-w.sessionCh:
-			s.fireAfterClosed()
+			s = s.resolve()
 			delete(w.sessions, s)
+			if w.close || !s.park() {
+				s.onClosed()
+			}
 			if w.close && len(w.sessions) == 0 {
 				w.network.Release(w.SC)
 				return
@@ -117,9 +121,8 @@ func (w *WSServer) Update() {
 		case <-w.closeSign:
 			w.closeSign = make(chan struct{})
 			w.close = true
-			for v := range w.sessions {
-				v.Close()
-			}
+			gResume.finish(w.SC)
+			w.drain.start(w.sessions)
 		here:
 			for {
 				select {
@@ -151,14 +154,15 @@ func (w *WSServer) Update() {
 			}
 
 			w.sessions[s] = struct{}{}
+			s.peers = w.sessions
 			go s.sendMsg()
 			go func() {
 				s.readMsg()
 				w.sessionCh <- s
 			}()
 
-			if !s.fireAfterConnected() {
-				s.Close()
+			if !s.onConnected() {
+				s.CloseWithReason(CloseRejected)
 				continue
 			}
 
@@ -166,11 +170,16 @@ func (w *WSServer) Update() {
 			for v := range w.sessions {
 				v.do()
 			}
+			w.drain.update(w.sessions)
 			return
 		}
 	}
 }
 
+func (w *WSServer) listener() net.Listener {
+	return w.raw
+}
+
 func (w *WSServer) Shutdown() {
 	log.WithField("ServiceInfo", w.SC).Trace("websocket server shutdown")
 	w.server.Close()
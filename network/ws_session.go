@@ -1,7 +1,6 @@
 package network
 
 import (
-	"bytes"
 	"io"
 	"net"
 	"time"
@@ -36,6 +35,7 @@ func (w *WSSession) SendMsg() {
 	var err error
 	var zero time.Time
 	var writer io.WriteCloser
+	send := w.Session.send
 here:
 	for {
 		if writer != nil {
@@ -44,24 +44,26 @@ here:
 		}
 
 		select {
-		case v := <-w.Session.send:
+		case v := <-send:
 			if v == nil {
 				break here
 			}
 
 			if writer, err = w.NextWriter(websocket.BinaryMessage); err != nil {
 				log.Warningf("websocket NextWriter error: %v", err)
+				w.Session.CloseWithReason(writeCloseReason(err))
 				break
 			}
 
 			if w.Session.SC.WriteTimeout > 0 {
 				w.Conn.SetWriteDeadline(time.Now().Add(w.Session.SC.WriteTimeout))
 			}
-			err = gPkgParser.EncodeByWriter(writer, v.data)
+			err = w.Session.SC.codec.Write(writer, v.data)
 			w.Conn.SetWriteDeadline(zero)
 			if err != nil {
-				log.Warningf("websocket EncodeByWriter error: %v", err)
-				putBuffer(bytes.NewBuffer(v.data))
+				log.Warningf("websocket write error: %v", err)
+				v.release()
+				w.Session.CloseWithReason(writeCloseReason(err))
 				break
 			}
 
@@ -80,20 +82,24 @@ func (w *WSSession) ReadMsg() {
 	for {
 		if _, reader, err = w.NextReader(); err != nil {
 			log.Warningf("websocket NextReader error: %v", err)
+			w.Session.CloseWithReason(wsCloseReason(err))
 			break
 		}
 
 		if w.Session.SC.ReadTimeout > 0 {
 			w.Conn.SetReadDeadline(time.Now().Add(w.Session.SC.ReadTimeout))
 		}
-		data, err := gPkgParser.DecodeByReader(reader)
+		data, err := w.Session.SC.codec.Read(reader)
 		w.Conn.SetReadDeadline(zero)
 		if err != nil {
-			log.Warningf("websocket DecodeByReader error: %v", err)
+			log.Warningf("websocket read error: %v", err)
+			w.Session.CloseWithReason(readCloseReason(err))
 			break
 		}
 
-		w.Session.recv <- data
+		if !w.Session.pushRecv(data) {
+			break
+		}
 	}
 
 	w.Session.Close()
@@ -102,3 +108,11 @@ func (w *WSSession) ReadMsg() {
 func (w *WSSession) Close() error {
 	return w.Conn.Close()
 }
+
+// wsCloseReason websocket读取数据失败的关闭原因
+func wsCloseReason(err error) CloseReason {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return ClosePeer
+	}
+	return readCloseReason(err)
+}